@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"task/pkg/logger"
+	"task/pkg/mrpc"
 )
 
 var config *ini.File
@@ -50,16 +51,165 @@ func HeartBeatInterval() uint {
 	return t
 }
 
+func DrainTimeout() uint {
+	t, err := GetSection("APP").Key("DRAIN_TIMEOUT").Uint()
+	if err != nil {
+		return 30
+	}
+	return t
+}
+
+// HookTimeout 前置和后置钩子的最长执行时间，0为不限制
+func HookTimeout() uint {
+	t, err := GetSection("APP").Key("HOOK_TIMEOUT").Uint()
+	if err != nil {
+		return 60
+	}
+	return t
+}
+
+// DaemonDrainTimeout 退出时等待常驻任务停止的最长时间，各任务配置的停止时间更长时以任务为准
+func DaemonDrainTimeout() uint {
+	t, err := GetSection("APP").Key("DAEMON_DRAIN_TIMEOUT").Uint()
+	if err != nil {
+		return 10
+	}
+	return t
+}
+
+// DaemonSurviveRestart 客户端退出时是否保留运行中的常驻任务，重启后重新接管
+func DaemonSurviveRestart() bool {
+	return GetSection("APP").Key("DAEMON_SURVIVE_RESTART").MustBool(false)
+}
+
+// ManageSession 是否由节点主动与管理端建立长连接，管理端无法直接访问节点时开启
+func ManageSession() bool {
+	return GetSection("APP").Key("MANAGE_SESSION").MustBool(false)
+}
+
 func RpcListenAddr() string {
 	return GetSection("APP").Key("RPC_LISTEN_ADDR").String()
 }
 
+// RpcTLS 节点与管理端之间的rpc传输加密配置，配置CA后双向校验证书
+func RpcTLS() mrpc.TLSOptions {
+	s := GetSection("RPC_TLS")
+	return mrpc.TLSOptions{
+		Enable:     s.Key("ENABLE").MustBool(false),
+		CaFile:     s.Key("CA_FILE").String(),
+		CertFile:   s.Key("CERT_FILE").String(),
+		KeyFile:    s.Key("KEY_FILE").String(),
+		ServerName: s.Key("SERVER_NAME").String(),
+	}
+}
+
+// RpcSecret 节点与管理端之间rpc调用签名使用的共享密钥，为空时不校验
+func RpcSecret() string {
+	return GetSection("RPC_AUTH").Key("SECRET").String()
+}
+
 func ManageListenAddr() string {
 	return GetSection("APP").Key("MANAGE_LISTEN_ADDR").String()
 }
 
+func CrontabLogMaxAge() uint {
+	t, _ := GetSection("CRONTAB_LOG").Key("MAX_AGE").Uint()
+	return t
+}
+
+func CrontabLogMaxRows() uint {
+	t, _ := GetSection("CRONTAB_LOG").Key("MAX_ROWS").Uint()
+	return t
+}
+
+func CrontabLogPurgeInterval() uint {
+	t, _ := GetSection("CRONTAB_LOG").Key("PURGE_INTERVAL").Uint()
+	if t == 0 {
+		return 3600
+	}
+	return t
+}
+
+func DaemonLogRoot() string {
+	return "runtime/log/daemon"
+}
+
 func DaemonLogPath(ID uint, d string) string {
-	return filepath.Join("runtime/log/daemon", d, strconv.Itoa(int(ID))+".log")
+	return filepath.Join(DaemonLogRoot(), d, strconv.Itoa(int(ID))+".log")
+}
+
+// DaemonInstanceLogPath 0号实例沿用单实例的日志路径
+func DaemonInstanceLogPath(ID uint, index uint, d string) string {
+	if index == 0 {
+		return DaemonLogPath(ID, d)
+	}
+	return filepath.Join(DaemonLogRoot(), d, strconv.Itoa(int(ID))+"_"+strconv.Itoa(int(index))+".log")
+}
+
+// DaemonSpoolPath 常驻任务进程输出的暂存文件，客户端读取后写入日志
+func DaemonSpoolPath(ID uint, index uint, stream string) string {
+	return filepath.Join("runtime/daemon/spool", strconv.Itoa(int(ID))+"_"+strconv.Itoa(int(index))+"."+stream)
+}
+
+func DaemonLogMaxSize() uint {
+	t, _ := GetSection("DAEMON_LOG").Key("MAX_SIZE").Uint()
+	return t
+}
+
+func DaemonLogMaxAge() uint {
+	t, _ := GetSection("DAEMON_LOG").Key("MAX_AGE").Uint()
+	return t
+}
+
+func DaemonLogMaxTotal() uint {
+	t, _ := GetSection("DAEMON_LOG").Key("MAX_TOTAL").Uint()
+	return t
+}
+
+func DaemonLogCompress() bool {
+	return GetSection("DAEMON_LOG").Key("COMPRESS").MustBool(true)
+}
+
+func DaemonLogPurgeInterval() uint {
+	t, _ := GetSection("DAEMON_LOG").Key("PURGE_INTERVAL").Uint()
+	if t == 0 {
+		return 3600
+	}
+	return t
+}
+
+func DaemonStatInterval() uint {
+	t, _ := GetSection("DAEMON_STAT").Key("INTERVAL").Uint()
+	if t == 0 {
+		return 10
+	}
+	return t
+}
+
+func DaemonStatHistory() int {
+	t, _ := GetSection("DAEMON_STAT").Key("HISTORY").Int()
+	if t <= 0 {
+		return 60
+	}
+	return t
+}
+
+func ArtifactRoot() string {
+	return "runtime/artifact"
+}
+
+func ArtifactPath(crontabID uint, runID string) string {
+	return filepath.Join(ArtifactRoot(), strconv.Itoa(int(crontabID)), runID)
+}
+
+func ArtifactMaxSize() uint {
+	t, _ := GetSection("ARTIFACT").Key("MAX_SIZE").Uint()
+	return t
+}
+
+func ArtifactMaxAge() uint {
+	t, _ := GetSection("ARTIFACT").Key("MAX_AGE").Uint()
+	return t
 }
 
 func GetLogger() *logger.ZapLogger {
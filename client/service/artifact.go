@@ -0,0 +1,182 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"task/client/config"
+	"task/pkg/helper"
+	"task/pkg/proto"
+	"time"
+)
+
+const artifactChunkSize = 1 << 20
+
+// collectArtifacts 将本次执行产生的文件按执行ID归档，超出大小限制的文件会被跳过
+func (p *crontabJobProcess) collectArtifacts() {
+	if len(p.crontabJob.value.Artifacts) == 0 || p.crontabJob.value.Dir == "" {
+		return
+	}
+	dir, err := filepath.Abs(p.crontabJob.value.Dir)
+	if err != nil {
+		return
+	}
+	// 工作目录本身可以是符号链接，目录内的文件按解析后的真实路径判断是否越界
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return
+	}
+	dst := config.ArtifactPath(p.crontabJob.id, p.runContext.RunID)
+	maxSize := int64(config.ArtifactMaxSize()) * 1024 * 1024
+	var total int64
+	var msg []string
+	for _, pattern := range p.crontabJob.value.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			msg = append(msg, fmt.Sprintf("规则 %s 不合法", pattern))
+			continue
+		}
+		for _, m := range matches {
+			rel, err := filepath.Rel(dir, m)
+			if err != nil || !withinDir(rel) {
+				continue
+			}
+			// 符号链接及其所在目录可能指向工作目录以外，只归档真实路径仍在工作目录内的普通文件
+			realPath, err := filepath.EvalSymlinks(m)
+			if err != nil {
+				continue
+			}
+			if realRel, err := filepath.Rel(realDir, realPath); err != nil || !withinDir(realRel) {
+				msg = append(msg, fmt.Sprintf("%s 指向工作目录以外，已跳过", rel))
+				continue
+			}
+			fi, err := os.Lstat(realPath)
+			if err != nil || !fi.Mode().IsRegular() || fi.ModTime().Before(p.startTime) {
+				continue
+			}
+			if maxSize > 0 && total+fi.Size() > maxSize {
+				msg = append(msg, fmt.Sprintf("%s 超出归档大小限制，已跳过", rel))
+				continue
+			}
+			if err = copyArtifact(realPath, filepath.Join(dst, rel)); err != nil {
+				msg = append(msg, fmt.Sprintf("%s 归档失败,%s", rel, err.Error()))
+				continue
+			}
+			total += fi.Size()
+		}
+	}
+	if len(msg) > 0 {
+		p.execResult += "[artifact]\n" + strings.Join(msg, "\n") + "\n"
+	}
+}
+
+// withinDir 相对路径未跳出基准目录
+func withinDir(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func copyArtifact(src string, dst string) error {
+	// 校验之后文件被替换为符号链接时拒绝打开
+	in, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := helper.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func purgeArtifacts() int {
+	maxAge := config.ArtifactMaxAge()
+	if maxAge == 0 {
+		return 0
+	}
+	before := time.Now().Add(-time.Duration(maxAge) * 24 * time.Hour)
+	runs, _ := filepath.Glob(filepath.Join(config.ArtifactRoot(), "*", "*"))
+	var n int
+	for _, r := range runs {
+		fi, err := os.Stat(r)
+		if err != nil || !fi.IsDir() || fi.ModTime().After(before) {
+			continue
+		}
+		if os.RemoveAll(r) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func artifactList(crontabID uint, runID string) ([]*proto.CrontabArtifact, error) {
+	root, err := artifactRunPath(crontabID, runID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*proto.CrontabArtifact, 0)
+	if !helper.FileExist(root) {
+		return list, nil
+	}
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		list = append(list, &proto.CrontabArtifact{
+			Name:    filepath.ToSlash(rel),
+			Size:    fi.Size(),
+			ModTime: uint(fi.ModTime().Unix()),
+		})
+		return nil
+	})
+	return list, err
+}
+
+func artifactRead(request *proto.CrontabArtifactArgs, response *proto.CrontabArtifactChunk) error {
+	root, err := artifactRunPath(request.CrontabID, request.RunID)
+	if err != nil {
+		return err
+	}
+	name := filepath.Clean(filepath.FromSlash(request.Name))
+	if name == "." || !withinDir(name) {
+		return errors.New("文件名不合法")
+	}
+	f, err := os.Open(filepath.Join(root, name))
+	if err != nil {
+		return errors.New("文件不存在")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	response.Size = fi.Size()
+	response.Data = make([]byte, artifactChunkSize)
+	n, err := f.ReadAt(response.Data, request.Offset)
+	response.Data = response.Data[:n]
+	if err != nil && err != io.EOF {
+		return err
+	}
+	response.EOF = request.Offset+int64(n) >= fi.Size()
+	return nil
+}
+
+func artifactRunPath(crontabID uint, runID string) (string, error) {
+	if runID == "" || runID != filepath.Base(runID) || strings.HasPrefix(runID, ".") {
+		return "", errors.New("执行ID不合法")
+	}
+	return config.ArtifactPath(crontabID, runID), nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	cases := []struct {
+		rel  string
+		want bool
+	}{
+		{"a.txt", true},
+		{"sub/a.txt", true},
+		{"..a.txt", true},
+		{"..", false},
+		{"../a.txt", false},
+		{"/etc/passwd", false},
+	}
+	for _, c := range cases {
+		if got := withinDir(c.rel); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.rel, got, c.want)
+		}
+	}
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "work")
+	outside := filepath.Join(root, "outside")
+	for _, d := range []string{filepath.Join(dir, "out"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := newCrontabJobProcess(&crontabJob{id: 21, value: &model.Crontab{Dir: dir}})
+	p.cancel()
+	p.startTime = time.Now().Add(-time.Minute)
+	writeFile(t, filepath.Join(dir, "out", "a.txt"), "a")
+	writeFile(t, filepath.Join(dir, "out", "b.log"), "b")
+	writeFile(t, filepath.Join(dir, "old.txt"), "old")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// 归档大小上限为1MB
+	big := strings.Repeat("x", 700*1024)
+	writeFile(t, filepath.Join(dir, "big1.bin"), big)
+	writeFile(t, filepath.Join(dir, "big2.bin"), big)
+	writeFile(t, filepath.Join(outside, "secret.txt"), "secret")
+	links := map[string]string{
+		filepath.Join(dir, "in.link"):       filepath.Join(dir, "out", "a.txt"),
+		filepath.Join(dir, "escape.link"):   filepath.Join(outside, "secret.txt"),
+		filepath.Join(dir, "escape"):        outside,
+		filepath.Join(dir, "relative.link"): filepath.Join("..", "outside", "secret.txt"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		name     string
+		patterns []string
+		want     []string
+		wantMsg  []string
+	}{
+		{"glob", []string{"out/*.txt", "out/b.log"}, []string{"out/a.txt", "out/b.log"}, nil},
+		{"skip old files", []string{"*.txt"}, nil, nil},
+		{"size limit", []string{"*.bin"}, []string{"big1.bin"}, []string{"big2.bin 超出归档大小限制"}},
+		{"parent dir", []string{"../outside/*"}, nil, nil},
+		{"symlink inside", []string{"in.link"}, []string{"in.link"}, nil},
+		{"symlink outside", []string{"escape.link", "relative.link"}, nil, []string{"escape.link 指向工作目录以外", "relative.link 指向工作目录以外"}},
+		{"symlink dir outside", []string{"escape/*"}, nil, []string{"escape/secret.txt 指向工作目录以外"}},
+		{"invalid pattern", []string{"["}, nil, []string{"规则 [ 不合法"}},
+	}
+	for _, c := range cases {
+		p.runContext.RunID = strings.ReplaceAll(c.name, " ", "-")
+		p.crontabJob.value.Artifacts = c.patterns
+		p.execResult = ""
+		p.collectArtifacts()
+		list, err := artifactList(21, p.runContext.RunID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, a := range list {
+			got = append(got, a.Name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		for _, m := range c.wantMsg {
+			if !strings.Contains(p.execResult, m) {
+				t.Fatalf("%s: result %q should contain %q", c.name, p.execResult, m)
+			}
+		}
+		if len(c.wantMsg) == 0 && p.execResult != "" {
+			t.Fatalf("%s: unexpected result %q", c.name, p.execResult)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(config.ArtifactPath(21, "symlink-inside"), "in.link"))
+	if err != nil || string(b) != "a" {
+		t.Fatalf("symlink inside should be archived as a copy, got %q %v", b, err)
+	}
+}
+
+func TestArtifactRead(t *testing.T) {
+	root := config.ArtifactPath(22, "run")
+	for _, f := range []string{"a.txt", "sub/b.txt", "..c.txt"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, f)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(root, f), "content of "+f)
+	}
+	// 与执行目录同级的文件不能通过相对路径读取
+	writeFile(t, filepath.Join(root, "..", "secret.txt"), "secret")
+	cases := []struct {
+		runID   string
+		name    string
+		offset  int64
+		want    string
+		wantErr string
+	}{
+		{"run", "a.txt", 0, "content of a.txt", ""},
+		{"run", "a.txt", 11, "a.txt", ""},
+		{"run", "sub/b.txt", 0, "content of sub/b.txt", ""},
+		{"run", "sub/../a.txt", 0, "content of a.txt", ""},
+		{"run", "..c.txt", 0, "content of ..c.txt", ""},
+		{"run", "../secret.txt", 0, "", "文件名不合法"},
+		{"run", "sub/../../secret.txt", 0, "", "文件名不合法"},
+		{"run", "/etc/passwd", 0, "", "文件名不合法"},
+		{"run", ".", 0, "", "文件名不合法"},
+		{"run", "missing.txt", 0, "", "文件不存在"},
+		{"..", "secret.txt", 0, "", "执行ID不合法"},
+		{"run/..", "secret.txt", 0, "", "执行ID不合法"},
+		{"", "a.txt", 0, "", "执行ID不合法"},
+	}
+	for _, c := range cases {
+		var chunk proto.CrontabArtifactChunk
+		err := artifactRead(&proto.CrontabArtifactArgs{CrontabID: 22, RunID: c.runID, Name: c.name, Offset: c.offset}, &chunk)
+		if c.wantErr != "" {
+			if err == nil || err.Error() != c.wantErr {
+				t.Fatalf("%s %s: got %v, want %s", c.runID, c.name, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %s: %v", c.runID, c.name, err)
+		}
+		if string(chunk.Data) != c.want || !chunk.EOF {
+			t.Fatalf("%s %s: got %q eof %v, want %q", c.runID, c.name, chunk.Data, chunk.EOF, c.want)
+		}
+	}
+}
+
+func TestPurgeArtifacts(t *testing.T) {
+	key := config.GetSection("ARTIFACT").Key("MAX_AGE")
+	t.Cleanup(func() {
+		key.SetValue("0")
+	})
+	old := time.Now().Add(-49 * time.Hour)
+	runs := map[string]bool{
+		config.ArtifactPath(23, "old"):    true,
+		config.ArtifactPath(23, "recent"): false,
+		config.ArtifactPath(24, "old"):    true,
+	}
+	for r, expired := range runs {
+		if err := os.MkdirAll(r, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(r, "a.txt"), "a")
+		if expired {
+			if err := os.Chtimes(r, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	cases := []struct {
+		maxAge string
+		want   int
+	}{
+		{"0", 0},
+		{"3", 0},
+		{"2", 2},
+	}
+	for _, c := range cases {
+		key.SetValue(c.maxAge)
+		if n := purgeArtifacts(); n != c.want {
+			t.Fatalf("max age %s: purged %d, want %d", c.maxAge, n, c.want)
+		}
+	}
+	for r, expired := range runs {
+		if _, err := os.Stat(r); os.IsNotExist(err) != expired {
+			t.Fatalf("%s: removed %v, want %v", r, os.IsNotExist(err), expired)
+		}
+	}
+}
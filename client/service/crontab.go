@@ -2,11 +2,13 @@ package service
 
 import (
 	"bufio"
+	"bytes"
 	"container/heap"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
@@ -19,23 +21,31 @@ import (
 	"task/pkg/helper"
 	"task/pkg/mrpc"
 	"task/pkg/proto"
+	"text/template"
 	"time"
 )
 
 type item = pkgcrontab.PriorityItem
 
+var errClosing = errors.New("节点正在关闭")
+
 type crontab struct {
-	jobs     map[uint]*crontabJob
-	onceJobs map[string]*crontabJob
-	queue    pkgcrontab.PriorityQueue
-	mux      sync.RWMutex
-	ready    chan *item
+	jobs      map[uint]*crontabJob
+	onceJobs  map[string]*crontabJob
+	runs      map[string]*crontabJobProcess
+	queue     pkgcrontab.PriorityQueue
+	mux       sync.RWMutex
+	ready     chan *item
+	purgeStat *crontabLogPurgeStat
+	runWg     sync.WaitGroup
+	closing   bool
 }
 
 type crontabJob struct {
 	id           uint
 	crontab      *crontab
 	once         bool
+	reboot       bool
 	uniqueId     string
 	userId       uint
 	timeExpr     string
@@ -45,36 +55,69 @@ type crontabJob struct {
 }
 
 type crontabJobProcess struct {
-	id         uint32
-	crontabJob *crontabJob
-	ctx        context.Context
-	cancel     context.CancelFunc
-	execStatus string
-	execMsg    string
-	execResult string
+	id          uint32
+	crontabJob  *crontabJob
+	ctx         context.Context
+	cancel      context.CancelFunc
+	execStatus  string
+	execMsg     string
+	execResult  string
+	pid         int
+	exitCode    int
+	startTime   time.Time
+	interrupted bool
+	env         []string
+	secrets     []string
+	runContext  *crontabRunContext
+}
+
+// crontabRunContext 每次执行注入的上下文，任务开启模板时同时作为命令模板的数据
+type crontabRunContext struct {
+	JobID         uint
+	RunID         string
+	ScheduledTime runTime
+	Node          string
+	Trigger       string
+	UserID        uint
+}
+
+type runTime struct {
+	time.Time
+}
+
+func (t runTime) String() string {
+	return t.Format(time.RFC3339)
 }
 
 func newCrontab() *crontab {
 	return &crontab{
-		jobs:     make(map[uint]*crontabJob),
-		onceJobs: make(map[string]*crontabJob),
-		queue:    make(pkgcrontab.PriorityQueue, 0, 100),
-		ready:    make(chan *item, 100),
+		jobs:      make(map[uint]*crontabJob),
+		onceJobs:  make(map[string]*crontabJob),
+		runs:      make(map[string]*crontabJobProcess),
+		queue:     make(pkgcrontab.PriorityQueue, 0, 100),
+		ready:     make(chan *item, 100),
+		purgeStat: newCrontabLogPurgeStat(),
 	}
 }
 
 func (c *crontab) start() {
 	c.recovery()
 	go c.run()
+	go c.purgeLog()
 }
 
 func (c *crontab) run() {
 	go c.checkReady()
 	for i := range c.ready {
 		jobID := i.Value.(*crontabJob).id
-		if j, ok := c.jobs[jobID]; ok {
+		c.mux.Lock()
+		j, ok := c.jobs[jobID]
+		// 与shutdown的closing检查在同一把锁内登记，避免Wait之后才Add
+		if ok && !c.closing {
+			c.runWg.Add(1)
 			go j.exec()
 		}
+		c.mux.Unlock()
 	}
 }
 
@@ -100,11 +143,16 @@ func (c *crontab) checkReady() {
 }
 
 func (c *crontab) recovery() {
+	c.reportInterrupted()
 	var crontabJobs []*model.Crontab
 	err := model.Task().Where("status in (?)", []string{model.StatusTiming, model.StatusRunning}).Find(&crontabJobs).Error
 	if err == nil {
 		var j *crontabJob
 		for _, v := range crontabJobs {
+			if v.TimeExpr == model.TimeExprReboot {
+				c.addRebootJob(v)
+				continue
+			}
 			j, err = c.addJob(&crontabJob{
 				id:       v.ID,
 				timeExpr: v.TimeExpr,
@@ -120,6 +168,25 @@ func (c *crontab) recovery() {
 	}
 }
 
+// reportInterrupted 上次退出时没有结束时间的记录，说明客户端未能正常收尾，包括执行完成后还在运行后置钩子或归档产物的记录
+func (c *crontab) reportInterrupted() {
+	var logs []*model.CrontabLog
+	err := model.Task().Where("end_time=0").Find(&logs).Error
+	if err != nil || len(logs) == 0 {
+		return
+	}
+	IDS := make([]uint, 0, len(logs))
+	for _, l := range logs {
+		IDS = append(IDS, l.ID)
+		Zap.Sugar().Warnf("[Crontab]%d run %s started at %s was interrupted by client exit\n", l.CrontabID, l.RunID, time.Unix(int64(l.StartTime), 0).Format(proto.TimeLayout))
+	}
+	model.Task().Model(&model.CrontabLog{}).Where("id in (?)", IDS).Updates(map[string]interface{}{
+		"status":   model.ExecStatusInterrupted,
+		"end_time": uint(time.Now().Unix()),
+		"result":   "客户端异常退出，执行被中断",
+	})
+}
+
 func (c *crontab) addJob(j *crontabJob) (*crontabJob, error) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
@@ -171,8 +238,40 @@ func (c *crontab) removeQueueItem(jobID uint) {
 	}
 }
 
+// addRebootJob 客户端启动后延迟执行一次，延迟期间停止或删除任务则不再执行
+func (c *crontab) addRebootJob(v *model.Crontab) {
+	j, err := c.addOnceJob(&crontabJob{
+		id:     v.ID,
+		reboot: true,
+	})
+	if err != nil {
+		return
+	}
+	delay := time.Duration(v.RebootDelay) * time.Second
+	model.Task().Model(&model.Crontab{}).Where("id=?", v.ID).Updates(map[string]interface{}{
+		"status":         model.StatusTiming,
+		"next_exec_time": uint(time.Now().Add(delay).Unix()),
+	})
+	time.AfterFunc(delay, func() {
+		c.mux.Lock()
+		_, ok := c.onceJobs[j.uniqueId]
+		ok = ok && !c.closing
+		if ok {
+			c.runWg.Add(1)
+		}
+		c.mux.Unlock()
+		if ok {
+			j.exec()
+		}
+	})
+}
+
 func (c *crontab) addOnceJob(j *crontabJob) (*crontabJob, error) {
 	c.mux.Lock()
+	if c.closing {
+		c.mux.Unlock()
+		return nil, errClosing
+	}
 	j.once = true
 	j.crontab = c
 	j.uniqueId = helper.UUID()
@@ -181,6 +280,10 @@ func (c *crontab) addOnceJob(j *crontabJob) (*crontabJob, error) {
 		return nil, errors.New("ID重复")
 	}
 	c.onceJobs[j.uniqueId] = j
+	// 手动执行由调用方随即exec，开机任务延迟到期后再登记
+	if !j.reboot {
+		c.runWg.Add(1)
+	}
 	c.mux.Unlock()
 	return j, nil
 }
@@ -205,24 +308,107 @@ func (c *crontab) kill(jobID uint) {
 	c.mux.Unlock()
 }
 
-func (c *crontab) killAll() {
+func (c *crontab) addRun(p *crontabJobProcess) {
 	c.mux.Lock()
-	if len(c.jobs) > 0 {
-		for ID, j := range c.jobs {
-			delete(c.jobs, ID)
-			j.process.cancel()
+	c.runs[p.runContext.RunID] = p
+	c.mux.Unlock()
+}
+
+func (c *crontab) delRun(p *crontabJobProcess) {
+	c.mux.Lock()
+	delete(c.runs, p.runContext.RunID)
+	if p.crontabJob.once {
+		delete(c.onceJobs, p.crontabJob.uniqueId)
+	}
+	c.mux.Unlock()
+}
+
+// killRun 只终止属于crontabIDS中任务的执行，返回执行所属的任务ID
+func (c *crontab) killRun(runID string, crontabIDS []uint) (uint, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	p, ok := c.runs[runID]
+	if !ok {
+		return 0, false
+	}
+	for _, ID := range crontabIDS {
+		if p.crontabJob.id == ID {
+			p.cancel()
+			return ID, true
 		}
 	}
-	if len(c.onceJobs) > 0 {
-		for uniqueID, j := range c.onceJobs {
-			delete(c.onceJobs, uniqueID)
-			j.process.cancel()
+	return 0, false
+}
+
+func (c *crontab) runList(crontabID uint) []*proto.CrontabRun {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	runs := make([]*proto.CrontabRun, 0, len(c.runs))
+	for _, p := range c.runs {
+		if crontabID > 0 && p.crontabJob.id != crontabID {
+			continue
 		}
+		runs = append(runs, &proto.CrontabRun{
+			RunID:     p.runContext.RunID,
+			CrontabID: p.crontabJob.id,
+			Name:      p.crontabJob.value.Name,
+			Pid:       p.pid,
+			StartTime: uint(p.startTime.Unix()),
+			Trigger:   p.runContext.Trigger,
+			UserID:    p.runContext.UserID,
+		})
+	}
+	return runs
+}
+
+func (c *crontab) isInterrupted(p *crontabJobProcess) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return p.interrupted
+}
+
+func (c *crontab) killAll() {
+	c.mux.Lock()
+	for ID := range c.jobs {
+		delete(c.jobs, ID)
+		c.removeQueueItem(ID)
+	}
+	for uniqueID := range c.onceJobs {
+		delete(c.onceJobs, uniqueID)
+	}
+	for _, p := range c.runs {
+		p.interrupted = true
+		p.cancel()
 	}
 	c.mux.Unlock()
 }
 
+// shutdown 停止派发新的执行，等待执行中的任务结束，超时后强杀并记录为中断
+func (c *crontab) shutdown(timeout time.Duration) {
+	c.mux.Lock()
+	c.closing = true
+	c.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		c.runWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+	c.killAll()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		Zap.Sugar().Errorln("[Crontab]interrupted runs did not finish in time")
+	}
+}
+
+// exec 调用前需在c.mux内检查closing并runWg.Add(1)
 func (j *crontabJob) exec() {
+	defer j.crontab.runWg.Done()
 	var err error
 	if j.once {
 		err = model.Task().Take(&j.value, "id=?", j.id).Error
@@ -241,12 +427,30 @@ func (j *crontabJob) exec() {
 	sTime := time.Now()
 	p := newCrontabJobProcess(j)
 	j.process = p
+	j.crontab.addRun(p)
+	l := &model.CrontabLog{
+		CrontabID:  j.id,
+		RunID:      p.runContext.RunID,
+		Status:     model.StatusRunning,
+		Once:       uint(helper.BoolToInt(j.once && !j.reboot)),
+		Trigger:    p.runContext.Trigger,
+		StartTime:  uint(sTime.Unix()),
+		ExecUserID: p.runContext.UserID,
+		CreateTime: uint(sTime.Unix()),
+	}
+	model.Task().Create(l)
 	defer func() {
 		if e := recover(); e != nil {
 			Zap.Sugar().Errorf("[Crontab]%s exec panic %s \n", j.value.Name, e)
 		}
+		j.crontab.delRun(p)
+		if j.crontab.isInterrupted(p) {
+			p.execStatus = model.ExecStatusInterrupted
+			p.execMsg = "客户端关闭，执行被中断"
+		}
+		p.execMsg = maskSecrets(p.execMsg, p.secrets)
+		p.execResult = maskSecrets(p.execResult, p.secrets)
 		costTime, _ := strconv.ParseFloat(fmt.Sprintf("%.4f", time.Now().Sub(sTime).Seconds()), 64)
-		userId := j.userId
 		data := map[string]interface{}{
 			"last_exec_time":   sTime.Unix(),
 			"last_cost_time":   costTime,
@@ -267,30 +471,47 @@ func (j *crontabJob) exec() {
 			} else {
 				data["next_exec_time"] = int(nj.nextExecTime.Unix())
 			}
-			userId = j.value.UpdateUserID
+		} else if j.reboot {
+			data["next_exec_time"] = 0
 		}
 		model.Task().Model(&j.value).Updates(data)
-		model.Task().Create(&model.CrontabLog{
-			CrontabID:  j.id,
-			Status:     p.execStatus,
-			Once:       uint(helper.BoolToInt(j.once)),
-			StartTime:  uint(sTime.Unix()),
-			EndTime:    uint(time.Now().Unix()),
-			CostTime:   costTime,
-			Result:     p.execResult,
-			ExecUserID: userId,
-			CreateTime: uint(time.Now().Unix()),
+		model.Task().Model(l).Updates(map[string]interface{}{
+			"status":    p.execStatus,
+			"end_time":  uint(time.Now().Unix()),
+			"cost_time": costTime,
+			"result":    p.execResult,
 		})
-		if p.execStatus == model.ExecStatusError {
+		if p.execStatus == model.ExecStatusError || p.execStatus == model.ExecStatusPreHookFailed {
 			p.triggerError()
 		}
 	}()
 	p.exec()
+	p.postHook()
+	p.collectArtifacts()
 }
 
 func newCrontabJobProcess(j *crontabJob) *crontabJobProcess {
 	p := &crontabJobProcess{
 		crontabJob: j,
+		startTime:  time.Now(),
+		runContext: &crontabRunContext{
+			JobID:         j.id,
+			RunID:         helper.UUID(),
+			ScheduledTime: runTime{j.nextExecTime},
+			Node:          config.NodeAddr(),
+			Trigger:       model.TriggerSchedule,
+			UserID:        j.value.UpdateUserID,
+		},
+	}
+	if j.once {
+		p.runContext.RunID = j.uniqueId
+		p.runContext.ScheduledTime = runTime{time.Now()}
+		p.runContext.Trigger = model.TriggerManual
+		p.runContext.UserID = j.userId
+	}
+	if j.reboot {
+		p.runContext.Trigger = model.TriggerReboot
+		p.runContext.UserID = j.value.UpdateUserID
 	}
 	p.ctx, p.cancel = context.WithCancel(context.Background())
 	return p
@@ -308,11 +529,38 @@ func (p *crontabJobProcess) exec() {
 			}
 		})
 	}
-	command := p.crontabJob.value.Command
-	args := strings.Split(command, " ")
-	cmd := p.getCmd(args[0], args[1:]...)
 	var stdout, stderr io.ReadCloser
 	var err error
+	p.env, p.secrets, err = resolveSecretEnv(p.crontabJob.value.Env)
+	if err != nil {
+		p.execStatus = model.ExecStatusError
+		p.execMsg = "密钥获取失败," + err.Error()
+		return
+	}
+	if len(p.env) == 0 {
+		p.env = os.Environ()
+	}
+	p.env = append(p.env, p.contextEnv()...)
+	command, err := p.renderCommand()
+	if err != nil {
+		p.execStatus = model.ExecStatusError
+		p.execMsg = "命令模板解析失败," + err.Error()
+		return
+	}
+	if p.crontabJob.value.PreHook != "" {
+		var out []byte
+		ctx, cancel := hookContext(p.ctx)
+		out, err = runHook(ctx, p.crontabJob.value.PreHook, p.crontabJob.value.Dir, p.crontabJob.value.User, p.env)
+		cancel()
+		p.execResult += hookLog(hookPre, out, err)
+		if err != nil {
+			p.execStatus = model.ExecStatusPreHookFailed
+			p.execMsg = "前置钩子执行失败," + err.Error()
+			return
+		}
+	}
+	args := strings.Split(command, " ")
+	cmd := p.getCmd(args[0], args[1:]...)
 	stdout, err = cmd.StdoutPipe()
 	if err != nil {
 		p.execStatus = model.ExecStatusError
@@ -337,9 +585,14 @@ func (p *crontabJobProcess) exec() {
 		p.execMsg = "进程启动失败," + err.Error()
 		return
 	}
+	p.crontabJob.crontab.mux.Lock()
+	p.pid = cmd.Process.Pid
+	p.crontabJob.crontab.mux.Unlock()
 	reader := bufio.NewReader(stdout)
 	readerErr := bufio.NewReader(stderr)
+	readDone := make(chan struct{})
 	go func() {
+		defer close(readDone)
 		var line []byte
 		for {
 			line, _ = reader.ReadBytes('\n')
@@ -356,8 +609,13 @@ func (p *crontabJobProcess) exec() {
 			p.execResult += fmt.Sprintf("%s\n", line)
 		}
 	}()
+	// Wait会关闭输出管道，需在读取完成后调用，否则末尾的输出可能丢失
+	<-readDone
 	err = cmd.Wait()
 	finishChan <- struct{}{}
+	if cmd.ProcessState != nil {
+		p.exitCode = cmd.ProcessState.ExitCode()
+	}
 	if err != nil {
 		p.execStatus = model.ExecStatusError
 		p.execMsg = "执行失败," + err.Error()
@@ -367,15 +625,63 @@ func (p *crontabJobProcess) exec() {
 	p.execMsg = "执行成功"
 }
 
+// postHook 无论执行结果如何都会执行，执行结果通过环境变量传入
+func (p *crontabJobProcess) postHook() {
+	if p.crontabJob.value.PostHook == "" || p.env == nil {
+		return
+	}
+	env := hookOutcomeEnv(p.env, p.execStatus, p.exitCode, time.Now().Sub(p.startTime))
+	ctx, cancel := hookContext(context.Background())
+	defer cancel()
+	out, err := runHook(ctx, p.crontabJob.value.PostHook, p.crontabJob.value.Dir, p.crontabJob.value.User, env)
+	p.execResult += hookLog(hookPost, out, err)
+}
+
+func (p *crontabJobProcess) contextEnv() []string {
+	rc := p.runContext
+	return []string{
+		"TASK_JOB_ID=" + strconv.Itoa(int(rc.JobID)),
+		"TASK_RUN_ID=" + rc.RunID,
+		"TASK_SCHEDULED_AT=" + strconv.FormatInt(rc.ScheduledTime.Unix(), 10),
+		"TASK_NODE=" + rc.Node,
+		"TASK_TRIGGER=" + rc.Trigger,
+		"TASK_USER_ID=" + strconv.Itoa(int(rc.UserID)),
+	}
+}
+
+// renderCommand 开启模板的任务才渲染命令，避免命令本身包含的{{}}被当作模板解析
+func (p *crontabJobProcess) renderCommand() (string, error) {
+	command := p.crontabJob.value.Command
+	if p.crontabJob.value.Template != 1 || !strings.Contains(command, "{{") {
+		return command, nil
+	}
+	tpl, err := template.New("command").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, p.runContext); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 func (p *crontabJobProcess) getCmd(name string, arg ...string) *exec.Cmd {
 	cmd := exec.CommandContext(p.ctx, name, arg...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 	cmd.SysProcAttr.Setsid = true
+	// 终止时连同子进程一起结束，避免子进程持有输出管道使读取一直阻塞
+	cmd.Cancel = func() error {
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
 	if p.crontabJob.value.Dir != "" && helper.FileExist(p.crontabJob.value.Dir) {
 		cmd.Dir = p.crontabJob.value.Dir
 	}
-	if len(p.crontabJob.value.Env) > 0 {
-		cmd.Env = p.crontabJob.value.Env
+	if len(p.env) > 0 {
+		cmd.Env = p.env
 	}
 	if p.crontabJob.value.User != "" {
 		user, err := user.Lookup(p.crontabJob.value.User)
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"sync"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"time"
+)
+
+type crontabLogPurgeStat struct {
+	lastPurgeTime uint
+	lastPurged    int64
+	totalPurged   int64
+	crontabs      map[uint]int64
+	mux           sync.Mutex
+}
+
+func newCrontabLogPurgeStat() *crontabLogPurgeStat {
+	return &crontabLogPurgeStat{
+		crontabs: make(map[uint]int64),
+	}
+}
+
+func (s *crontabLogPurgeStat) add(purged map[uint]int64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.lastPurgeTime = uint(time.Now().Unix())
+	s.lastPurged = 0
+	for ID, n := range purged {
+		s.lastPurged += n
+		s.crontabs[ID] += n
+	}
+	s.totalPurged += s.lastPurged
+}
+
+func (s *crontabLogPurgeStat) get(reply *proto.CrontabLogPurgeReply) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	reply.LastPurgeTime = s.lastPurgeTime
+	reply.LastPurged = s.lastPurged
+	reply.TotalPurged = s.totalPurged
+	reply.Crontabs = make(map[uint]int64, len(s.crontabs))
+	for ID, n := range s.crontabs {
+		reply.Crontabs[ID] = n
+	}
+}
+
+func (c *crontab) purgeLog() {
+	ticker := time.NewTicker(time.Duration(config.CrontabLogPurgeInterval()) * time.Second)
+	for {
+		c.purgeStat.add(c.purge())
+		<-ticker.C
+	}
+}
+
+// purge 按保留策略清理执行日志，任务自身的配置优先于节点默认值
+func (c *crontab) purge() map[uint]int64 {
+	purged := make(map[uint]int64)
+	var cs []*model.Crontab
+	err := model.Task().Select("id", "log_max_age", "log_max_rows").Find(&cs).Error
+	if err != nil {
+		Zap.Sugar().Errorf("[Crontab]purge log failed, %s", err.Error())
+		return purged
+	}
+	defaultMaxAge := config.CrontabLogMaxAge()
+	defaultMaxRows := config.CrontabLogMaxRows()
+	IDS := make([]uint, 0, len(cs))
+	for _, v := range cs {
+		IDS = append(IDS, v.ID)
+		maxAge, maxRows := retention(v.LogMaxAge, defaultMaxAge), retention(v.LogMaxRows, defaultMaxRows)
+		if maxAge > 0 {
+			res := model.Task().Where("crontab_id=? and create_time<?", v.ID, c.purgeBefore(maxAge)).Delete(&model.CrontabLog{})
+			purged[v.ID] += res.RowsAffected
+		}
+		if maxRows > 0 {
+			var l model.CrontabLog
+			err = model.Task().Select("id").Where("crontab_id=?", v.ID).Order("id desc").Offset(int(maxRows)).Take(&l).Error
+			if err == nil {
+				res := model.Task().Where("crontab_id=? and id<=?", v.ID, l.ID).Delete(&model.CrontabLog{})
+				purged[v.ID] += res.RowsAffected
+			}
+		}
+	}
+	// 已删除任务遗留的日志按节点默认天数清理，计入ID 0
+	if defaultMaxAge > 0 {
+		m := model.Task().Where("create_time<?", c.purgeBefore(defaultMaxAge))
+		if len(IDS) > 0 {
+			m = m.Where("crontab_id not in (?)", IDS)
+		}
+		res := m.Delete(&model.CrontabLog{})
+		purged[0] += res.RowsAffected
+	}
+	if n := purgeArtifacts(); n > 0 {
+		Zap.Sugar().Infof("[Crontab]purged %d expired artifact runs\n", n)
+	}
+	for ID, n := range purged {
+		if n == 0 {
+			delete(purged, ID)
+		}
+	}
+	return purged
+}
+
+// retention 任务未设置时使用节点默认值，0为不清理
+func retention(v *uint, def uint) uint {
+	if v == nil {
+		return def
+	}
+	return *v
+}
+
+func (c *crontab) purgeBefore(days uint) uint {
+	return uint(time.Now().Unix()) - days*24*3600
+}
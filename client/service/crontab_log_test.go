@@ -0,0 +1,98 @@
+package service
+
+import (
+	"task/model"
+	"testing"
+	"time"
+)
+
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+func TestRetention(t *testing.T) {
+	cases := []struct {
+		name string
+		v    *uint
+		def  uint
+		want uint
+	}{
+		{"inherit default", nil, 30, 30},
+		{"inherit disabled default", nil, 0, 0},
+		{"own value", uintPtr(7), 30, 7},
+		{"keep forever", uintPtr(0), 30, 0},
+	}
+	for _, c := range cases {
+		if got := retention(c.v, c.def); got != c.want {
+			t.Fatalf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func resetCrontabTables(t *testing.T) {
+	t.Helper()
+	for _, table := range []string{"t_crontab", "t_crontab_log"} {
+		if err := model.Task().Exec("delete from " + table).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	// 节点默认保留30天、1000条
+	cases := []struct {
+		name       string
+		maxAge     *uint
+		maxRows    *uint
+		ages       []int
+		wantPurged int64
+		wantLeft   int64
+	}{
+		{"inherit node default", nil, nil, []int{40, 40, 35, 1, 0}, 3, 2},
+		{"keep forever", uintPtr(0), uintPtr(0), []int{400, 40, 1}, 0, 3},
+		{"keep rows", uintPtr(0), uintPtr(2), []int{5, 4, 3, 2, 1}, 3, 2},
+		{"keep days", uintPtr(7), nil, []int{10, 8, 3}, 2, 1},
+		{"keep days and rows", uintPtr(7), uintPtr(1), []int{10, 3, 2}, 2, 1},
+	}
+	now := time.Now()
+	IDS := make([]uint, len(cases))
+	for k, c := range cases {
+		v := &model.Crontab{Name: c.name, LogMaxAge: c.maxAge, LogMaxRows: c.maxRows}
+		if err := model.Task().Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+		IDS[k] = v.ID
+		for _, age := range c.ages {
+			model.Task().Create(&model.CrontabLog{CrontabID: v.ID, CreateTime: uint(now.AddDate(0, 0, -age).Unix())})
+		}
+	}
+	// 已删除任务遗留的日志按节点默认天数清理
+	const orphanID = 9999
+	for _, age := range []int{40, 1} {
+		model.Task().Create(&model.CrontabLog{CrontabID: orphanID, CreateTime: uint(now.AddDate(0, 0, -age).Unix())})
+	}
+	purged := newCrontab().purge()
+	for k, c := range cases {
+		if purged[IDS[k]] != c.wantPurged {
+			t.Fatalf("%s: purged %d, want %d", c.name, purged[IDS[k]], c.wantPurged)
+		}
+		var left int64
+		model.Task().Model(&model.CrontabLog{}).Where("crontab_id=?", IDS[k]).Count(&left)
+		if left != c.wantLeft {
+			t.Fatalf("%s: %d logs left, want %d", c.name, left, c.wantLeft)
+		}
+	}
+	if purged[0] != 1 {
+		t.Fatalf("orphan logs: purged %d, want 1", purged[0])
+	}
+	// 按条数保留时留下最新的记录
+	var l model.CrontabLog
+	model.Task().Where("crontab_id=?", IDS[2]).Order("id").First(&l)
+	if l.CreateTime != uint(now.AddDate(0, 0, -2).Unix()) {
+		t.Fatal("keep rows should keep the newest logs")
+	}
+}
@@ -0,0 +1,391 @@
+package service
+
+import (
+	"strings"
+	"task/model"
+	"task/pkg/helper"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestRenderCommand(t *testing.T) {
+	scheduled := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+	rc := &crontabRunContext{
+		JobID:         12,
+		RunID:         "run-1",
+		ScheduledTime: runTime{scheduled},
+		Node:          "127.0.0.1:9700",
+		Trigger:       model.TriggerSchedule,
+		UserID:        3,
+	}
+	cases := []struct {
+		name     string
+		template uint
+		command  string
+		want     string
+		wantErr  bool
+	}{
+		{"plain", 1, "sh -c 'echo hello'", "sh -c 'echo hello'", false},
+		{"ids", 1, "sh -c 'echo {{.JobID}} {{.RunID}} {{.UserID}}'", "sh -c 'echo 12 run-1 3'", false},
+		{"node and trigger", 1, "sh -c 'echo {{.Node}} {{.Trigger}}'", "sh -c 'echo 127.0.0.1:9700 schedule'", false},
+		{"scheduled time", 1, "sh -c 'echo {{.ScheduledTime}}'", "sh -c 'echo 2026-10-19T09:30:00Z'", false},
+		{"scheduled time format", 1, "sh -c 'echo {{.ScheduledTime.Format \"20060102\"}}'", "sh -c 'echo 20261019'", false},
+		{"unknown field", 1, "sh -c 'echo {{.Missing}}'", "", true},
+		{"syntax error", 1, "sh -c 'echo {{.JobID'", "", true},
+		// 未开启模板时命令中的{{}}原样执行
+		{"template off", 0, "docker ps --format '{{.Names}}'", "docker ps --format '{{.Names}}'", false},
+		{"template off with context field", 0, "sh -c 'echo {{.JobID}}'", "sh -c 'echo {{.JobID}}'", false},
+		{"template on", 1, "docker ps --format '{{.Names}}'", "", true},
+	}
+	for _, c := range cases {
+		p := &crontabJobProcess{
+			crontabJob: &crontabJob{value: &model.Crontab{Command: c.command, Template: c.template}},
+			runContext: rc,
+		}
+		got, err := p.renderCommand()
+		if c.wantErr {
+			if err == nil {
+				t.Fatalf("%s: render should fail, got %q", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: render failed, %v", c.name, err)
+		}
+		if got != c.want {
+			t.Fatalf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestExecLiteralBraces(t *testing.T) {
+	// test命令在参数原样传入时才返回0
+	cases := []struct {
+		name       string
+		template   uint
+		wantStatus string
+		wantMsg    string
+	}{
+		{"template off", 0, model.ExecStatusSuccess, "执行成功"},
+		{"template on", 1, model.ExecStatusError, "命令模板解析失败"},
+	}
+	for _, c := range cases {
+		p := newCrontabJobProcess(&crontabJob{id: 1, crontab: newCrontab(), value: &model.Crontab{Command: "test {{.Names}} = {{.Names}}", Template: c.template}})
+		p.exec()
+		p.cancel()
+		if p.execStatus != c.wantStatus || !strings.HasPrefix(p.execMsg, c.wantMsg) {
+			t.Fatalf("%s: got status %s msg %s", c.name, p.execStatus, p.execMsg)
+		}
+	}
+}
+
+func TestNewCrontabJobProcess(t *testing.T) {
+	value := &model.Crontab{ID: 5, UpdateUserID: 2}
+	cases := []struct {
+		name        string
+		job         *crontabJob
+		wantTrigger string
+		wantUserID  uint
+		wantRunID   string
+	}{
+		{"schedule", &crontabJob{id: 5, value: value}, model.TriggerSchedule, 2, ""},
+		{"manual", &crontabJob{id: 5, value: value, once: true, uniqueId: "manual-run", userId: 7}, model.TriggerManual, 7, "manual-run"},
+		{"reboot", &crontabJob{id: 5, value: value, once: true, reboot: true, uniqueId: "reboot-run"}, model.TriggerReboot, 2, "reboot-run"},
+	}
+	for _, c := range cases {
+		p := newCrontabJobProcess(c.job)
+		p.cancel()
+		rc := p.runContext
+		if rc.Trigger != c.wantTrigger || rc.UserID != c.wantUserID || rc.JobID != 5 {
+			t.Fatalf("%s: got trigger %s user %d job %d", c.name, rc.Trigger, rc.UserID, rc.JobID)
+		}
+		if c.wantRunID != "" && rc.RunID != c.wantRunID {
+			t.Fatalf("%s: got run ID %s, want %s", c.name, rc.RunID, c.wantRunID)
+		}
+		if c.wantRunID == "" && rc.RunID == "" {
+			t.Fatalf("%s: scheduled runs should get a run ID", c.name)
+		}
+	}
+}
+
+func TestRecoveryReboot(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	cases := []struct {
+		name       string
+		timeExpr   string
+		status     string
+		wantQueued bool
+		wantReboot bool
+	}{
+		{"reboot", model.TimeExprReboot, model.StatusTiming, false, true},
+		{"reboot left running", model.TimeExprReboot, model.StatusRunning, false, true},
+		{"reboot stopped", model.TimeExprReboot, model.StatusStopped, false, false},
+		{"schedule", "0 0 9 * * ?", model.StatusTiming, true, false},
+	}
+	IDS := make([]uint, len(cases))
+	for k, c := range cases {
+		v := &model.Crontab{Name: c.name, TimeExpr: c.timeExpr, Status: c.status, RebootDelay: 60}
+		if err := model.Task().Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+		IDS[k] = v.ID
+	}
+	c := newCrontab()
+	before := uint(time.Now().Add(time.Minute).Unix())
+	c.recovery()
+	after := uint(time.Now().Add(time.Minute).Unix())
+	reboots := make(map[uint]bool)
+	for _, j := range c.onceJobs {
+		if !j.reboot || j.userId != 0 {
+			t.Fatalf("crontab %d: unexpected once job", j.id)
+		}
+		reboots[j.id] = true
+	}
+	for k, cs := range cases {
+		ID := IDS[k]
+		_, queued := c.jobs[ID]
+		if queued != cs.wantQueued {
+			t.Fatalf("%s: queued %v, want %v", cs.name, queued, cs.wantQueued)
+		}
+		if reboots[ID] != cs.wantReboot {
+			t.Fatalf("%s: reboot job %v, want %v", cs.name, reboots[ID], cs.wantReboot)
+		}
+		var v model.Crontab
+		model.Task().First(&v, ID)
+		if cs.wantReboot && (v.Status != model.StatusTiming || v.NextExecTime < before || v.NextExecTime > after) {
+			t.Fatalf("%s: got status %s next exec time %d, want %s within [%d, %d]", cs.name, v.Status, v.NextExecTime, model.StatusTiming, before, after)
+		}
+		// 延迟期间停止任务则不再执行
+		c.kill(ID)
+	}
+	if len(c.onceJobs) != 0 || len(c.jobs) != 0 || c.queue.Len() != 0 {
+		t.Fatal("killed crontabs should leave no pending jobs")
+	}
+}
+
+func createCrontab(t *testing.T, v *model.Crontab) {
+	t.Helper()
+	if err := model.Task().Create(v).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startRun 以手动执行的方式在后台运行任务，返回执行结束时关闭的通道
+func startRun(c *crontab, ID uint) chan struct{} {
+	j := &crontabJob{id: ID, crontab: c, once: true, uniqueId: helper.UUID(), userId: 1}
+	c.mux.Lock()
+	c.onceJobs[j.uniqueId] = j
+	c.runWg.Add(1)
+	c.mux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		j.exec()
+		close(done)
+	}()
+	return done
+}
+
+// waitRuns 等待任务有n个执行且进程均已启动
+func waitRuns(t *testing.T, c *crontab, ID uint, n int) []*proto.CrontabRun {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		runs := c.runList(ID)
+		started := 0
+		for _, r := range runs {
+			if r.Pid > 0 {
+				started++
+			}
+		}
+		if len(runs) == n && started == n {
+			return runs
+		}
+	}
+	t.Fatalf("crontab %d should have %d started runs, got %d", ID, n, len(c.runList(ID)))
+	return nil
+}
+
+func crontabLogs(ID uint) []*model.CrontabLog {
+	var logs []*model.CrontabLog
+	model.Task().Where("crontab_id=?", ID).Order("id asc").Find(&logs)
+	return logs
+}
+
+func TestKillRun(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	v := &model.Crontab{Name: "kill run", Command: "sleep 30", Status: model.StatusTiming}
+	createCrontab(t, v)
+	c := newCrontab()
+	done := []chan struct{}{startRun(c, v.ID), startRun(c, v.ID)}
+	runs := waitRuns(t, c, v.ID, 2)
+	if runs[0].RunID == runs[1].RunID || runs[0].Trigger != model.TriggerManual {
+		t.Fatalf("runs should have their own run IDs, got %+v %+v", runs[0], runs[1])
+	}
+	if len(c.runList(v.ID+1)) != 0 {
+		t.Fatal("runs of other crontabs should not be listed")
+	}
+	cases := []struct {
+		name   string
+		runID  string
+		IDS    []uint
+		wantOk bool
+	}{
+		{"other crontab", runs[0].RunID, []uint{v.ID + 1}, false},
+		{"unknown run", "missing", []uint{v.ID}, false},
+		{"own run", runs[0].RunID, []uint{v.ID + 1, v.ID}, true},
+	}
+	for _, cs := range cases {
+		ID, ok := c.killRun(cs.runID, cs.IDS)
+		if ok != cs.wantOk || (ok && ID != v.ID) {
+			t.Fatalf("%s: got %d %v", cs.name, ID, ok)
+		}
+	}
+	// 只终止指定的执行
+	if left := waitRuns(t, c, v.ID, 1); left[0].RunID != runs[1].RunID {
+		t.Fatalf("run %s should still be running", runs[1].RunID)
+	}
+	c.killRun(runs[1].RunID, []uint{v.ID})
+	for _, d := range done {
+		<-d
+	}
+	logs := crontabLogs(v.ID)
+	if len(logs) != 2 {
+		t.Fatalf("got %d logs, want 2", len(logs))
+	}
+	want := map[string]bool{runs[0].RunID: true, runs[1].RunID: true}
+	for _, l := range logs {
+		if !want[l.RunID] || l.Status != model.ExecStatusError || l.Trigger != model.TriggerManual || l.EndTime == 0 {
+			t.Fatalf("unexpected log %+v", l)
+		}
+		delete(want, l.RunID)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	slow := &model.Crontab{Name: "slow", Command: "sleep 30", Status: model.StatusTiming}
+	fast := &model.Crontab{Name: "fast", Command: "sleep 0.3", Status: model.StatusTiming}
+	createCrontab(t, slow)
+	createCrontab(t, fast)
+	c := newCrontab()
+	done := []chan struct{}{startRun(c, slow.ID), startRun(c, fast.ID)}
+	waitRuns(t, c, slow.ID, 1)
+	waitRuns(t, c, fast.ID, 1)
+	start := time.Now()
+	c.shutdown(time.Second)
+	if cost := time.Since(start); cost < time.Second || cost > 5*time.Second {
+		t.Fatalf("shutdown should wait for the drain timeout, took %s", cost)
+	}
+	for _, d := range done {
+		<-d
+	}
+	cases := []struct {
+		name       string
+		ID         uint
+		wantStatus string
+	}{
+		{"finished within drain timeout", fast.ID, model.ExecStatusSuccess},
+		{"interrupted", slow.ID, model.ExecStatusInterrupted},
+	}
+	for _, cs := range cases {
+		logs := crontabLogs(cs.ID)
+		if len(logs) != 1 || logs[0].Status != cs.wantStatus || logs[0].EndTime == 0 {
+			t.Fatalf("%s: got logs %+v", cs.name, logs)
+		}
+		var v model.Crontab
+		model.Task().First(&v, cs.ID)
+		if v.LastExecStatus != cs.wantStatus {
+			t.Fatalf("%s: got last exec status %s", cs.name, v.LastExecStatus)
+		}
+	}
+	// 关闭后不再派发新的执行
+	c.mux.RLock()
+	closing := c.closing
+	c.mux.RUnlock()
+	if !closing {
+		t.Fatal("crontab should stop dispatching after shutdown")
+	}
+}
+
+func TestReportInterrupted(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	now := uint(time.Now().Unix())
+	logs := []*model.CrontabLog{
+		{CrontabID: 1, RunID: "lost", Status: model.StatusRunning, StartTime: now - 60},
+		{CrontabID: 1, RunID: "done", Status: model.ExecStatusSuccess, StartTime: now - 60, EndTime: now - 30},
+	}
+	for _, l := range logs {
+		if err := model.Task().Create(l).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	newCrontab().reportInterrupted()
+	cases := []struct {
+		runID      string
+		wantStatus string
+		wantResult string
+	}{
+		{"lost", model.ExecStatusInterrupted, "客户端异常退出，执行被中断"},
+		{"done", model.ExecStatusSuccess, ""},
+	}
+	for _, c := range cases {
+		var l model.CrontabLog
+		model.Task().First(&l, "run_id=?", c.runID)
+		if l.Status != c.wantStatus || l.Result != c.wantResult || l.EndTime == 0 {
+			t.Fatalf("%s: got %+v", c.runID, l)
+		}
+	}
+}
+
+func TestCrontabHooks(t *testing.T) {
+	resetCrontabTables(t)
+	t.Cleanup(func() {
+		resetCrontabTables(t)
+	})
+	cases := []struct {
+		name       string
+		preHook    string
+		command    string
+		wantStatus string
+		want       []string
+		notWant    []string
+	}{
+		{"hooks", "echo pre", "echo run", model.ExecStatusSuccess,
+			[]string{"[pre-hook]\npre\n", "run\n", "[post-hook]\n", "TASK_STATUS=Success\n", "TASK_EXIT_CODE=0\n", "TASK_DURATION="}, nil},
+		{"command failed", "", "false", model.ExecStatusError,
+			[]string{"TASK_STATUS=Error\n", "TASK_EXIT_CODE=1\n"}, []string{"[pre-hook]"}},
+		{"pre hook failed", "false", "echo run", model.ExecStatusPreHookFailed,
+			[]string{"[pre-hook] exit status 1\n", "TASK_STATUS=PreHookFailed\n"}, []string{"run\n"}},
+	}
+	for _, cs := range cases {
+		v := &model.Crontab{Name: cs.name, Command: cs.command, PreHook: cs.preHook, PostHook: "env", Status: model.StatusTiming}
+		createCrontab(t, v)
+		<-startRun(newCrontab(), v.ID)
+		logs := crontabLogs(v.ID)
+		if len(logs) != 1 || logs[0].Status != cs.wantStatus {
+			t.Fatalf("%s: got logs %+v", cs.name, logs)
+		}
+		for _, w := range cs.want {
+			if !strings.Contains(logs[0].Result, w) {
+				t.Fatalf("%s: result should contain %q, got %q", cs.name, w, logs[0].Result)
+			}
+		}
+		for _, w := range cs.notWant {
+			if strings.Contains(logs[0].Result, w) {
+				t.Fatalf("%s: result should not contain %q, got %q", cs.name, w, logs[0].Result)
+			}
+		}
+	}
+}
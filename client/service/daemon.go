@@ -1,16 +1,18 @@
 package service
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
 	"os/user"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"task/client/config"
 	"task/model"
@@ -20,228 +22,1004 @@ import (
 	"time"
 )
 
+var errStopping = errors.New("任务正在停止，请稍后重试")
+
 type daemon struct {
-	jobs  map[uint]*daemonJob
-	mux   sync.Mutex
-	ready chan *daemonJob
+	stats     *daemonStats
+	jobs      map[daemonKey]*daemonJob
+	mux       sync.Mutex
+	statusMux sync.Mutex
+	ready     chan *daemonJob
+	wg        sync.WaitGroup
+	closing   bool
+}
+
+// daemonKey 常驻任务的每个实例单独调度
+type daemonKey struct {
+	ID       uint
+	Instance uint
 }
 
 type daemonJob struct {
-	daemon  *daemon
-	value   *model.Daemon
-	ctx     context.Context
-	cancel  context.CancelFunc
-	sTime   time.Time
-	logPath string
-	logFile *os.File
-	errMsg  string
+	daemon   *daemon
+	value    *model.Daemon
+	instance uint
+	ctx      context.Context
+	cancel   context.CancelFunc
+	// done 进程退出并写完停止记录后关闭
+	done     chan struct{}
+	sTime    time.Time
+	logPath  string
+	logFile  *os.File
+	logSize  int64
+	logMux   sync.Mutex
+	errMsg   string
+	stopping bool
+	pid      atomic.Int64
+	env      []string
+	secrets  []string
+	spools   []*daemonSpool
+	spoolMux sync.Mutex
+	// restarts 滑动窗口内的重启时间，crashLoop 是否处于频繁崩溃状态
+	restarts  []time.Time
+	crashLoop atomic.Bool
+	// adopt 客户端重启前仍在运行的进程，首次执行时接管而不是重新启动
+	adopt *model.DaemonInstance
+	// trigger 下一次启动的触发方，userID 手动启动的操作人，stopBy 停止的触发方，stopUserID 手动停止的操作人
+	trigger    string
+	userID     uint
+	stopBy     string
+	stopUserID uint
 }
 
 func newDaemon() *daemon {
 	return &daemon{
-		jobs:  make(map[uint]*daemonJob),
+		stats: newDaemonStats(),
+		jobs:  make(map[daemonKey]*daemonJob),
 		ready: make(chan *daemonJob, 100),
 	}
 }
 
+func numprocs(v *model.Daemon) uint {
+	if v.Numprocs == 0 {
+		return 1
+	}
+	return v.Numprocs
+}
+
+var signals = map[string]syscall.Signal{
+	"TERM": syscall.SIGTERM,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignal 解析信号名，支持带或不带SIG前缀，未配置时返回默认信号
+func parseSignal(name string, def syscall.Signal) (syscall.Signal, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if name == "" {
+		return def, true
+	}
+	sig, ok := signals[name]
+	if !ok {
+		return def, false
+	}
+	return sig, true
+}
+
+func stopSignal(name string) (syscall.Signal, error) {
+	sig, ok := parseSignal(name, syscall.SIGTERM)
+	if !ok {
+		return sig, errors.New("停止信号不合法")
+	}
+	return sig, nil
+}
+
+func reloadSignal(name string) (syscall.Signal, error) {
+	sig, ok := parseSignal(name, syscall.SIGHUP)
+	if !ok || sig == syscall.SIGKILL {
+		return sig, errors.New("重载信号不合法")
+	}
+	return sig, nil
+}
+
+func stopWait(v *model.Daemon) time.Duration {
+	if v.StopWait == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(v.StopWait) * time.Second
+}
+
 func (d *daemon) start() {
-	var dj []*model.Daemon
-	err := model.Task().Where("status=? and end_time>?", model.StatusStopped, time.Now().Unix()-30).Order("id asc").Find(&dj).Error
-	if err == nil {
-		for _, j := range dj {
-			d.addJob(&daemonJob{value: j})
+	d.recover()
+	go d.run()
+	go d.purgeLog()
+	go d.sample()
+	go d.schedule()
+}
+
+// addDaemon 启动常驻任务的指定实例，instances为空时启动全部实例，userID为0时由客户端自动启动
+func (d *daemon) addDaemon(v *model.Daemon, instances []uint, userID uint) error {
+	return d.startDaemon(v, instances, startTrigger(userID), userID)
+}
+
+func (d *daemon) startDaemon(v *model.Daemon, instances []uint, trigger string, userID uint) error {
+	n := numprocs(v)
+	if len(instances) == 0 {
+		for i := uint(0); i < n; i++ {
+			instances = append(instances, i)
 		}
 	}
-	go d.run()
+	for _, i := range instances {
+		if i >= n {
+			return fmt.Errorf("%s 实例%d不存在", v.Name, i)
+		}
+	}
+	for _, i := range instances {
+		// 各实例独立更新状态，不共享同一个结构体
+		value := *v
+		err := d.addJob(&daemonJob{value: &value, instance: i, trigger: trigger, userID: userID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (d *daemon) addJob(j *daemonJob) {
+func (d *daemon) addJob(j *daemonJob) error {
 	j.daemon = d
 	d.mux.Lock()
-	d.jobs[j.value.ID] = j
+	if d.closing {
+		d.mux.Unlock()
+		return errClosing
+	}
+	if i, ok := d.jobs[j.key()]; ok {
+		stopping := i.stopping
+		d.mux.Unlock()
+		if stopping {
+			return errStopping
+		}
+		return nil
+	}
+	d.jobs[j.key()] = j
 	d.mux.Unlock()
 	d.ready <- j
+	return nil
+}
+
+// stopJob 调用方需持有锁，进程按停止信号退出前实例仍保留在jobs中，避免重复启动，返回仍需等待退出的实例，退出时再写入已停止
+func (d *daemon) stopJob(k daemonKey, j *daemonJob, trigger string, userID uint) *daemonJob {
+	if j.cancel == nil {
+		delete(d.jobs, k)
+		return nil
+	}
+	if !j.stopping {
+		j.stopping = true
+		j.stopBy = trigger
+		j.stopUserID = userID
+		// 客户端退出时停止的实例重启后恢复运行，不标记为停止中
+		if !d.closing {
+			_ = j.update(map[string]interface{}{"status": model.StatusStopping})
+		}
+	}
+	j.cancel()
+	return j
 }
 
-func (d *daemon) delJob(ID uint) {
+// delJob 停止常驻任务的全部实例
+func (d *daemon) delJob(ID uint, userID uint) []*daemonJob {
+	return d.stopDaemon(ID, startTrigger(userID), userID)
+}
+
+func (d *daemon) stopDaemon(ID uint, trigger string, userID uint) []*daemonJob {
+	var stopping []*daemonJob
 	d.mux.Lock()
-	if j, ok := d.jobs[ID]; ok {
-		delete(d.jobs, ID)
-		d.mux.Unlock()
+	for k, j := range d.jobs {
+		if k.ID == ID {
+			if j = d.stopJob(k, j, trigger, userID); j != nil {
+				stopping = append(stopping, j)
+			}
+		}
+	}
+	d.mux.Unlock()
+	return stopping
+}
+
+func (d *daemon) delInstance(k daemonKey, userID uint) {
+	d.mux.Lock()
+	if j, ok := d.jobs[k]; ok {
+		d.stopJob(k, j, startTrigger(userID), userID)
+	}
+	d.mux.Unlock()
+}
+
+// waitStopped 等待实例退出，最长等待各实例配置的停止时间，返回是否全部退出
+func waitStopped(jobs []*daemonJob) bool {
+	for _, j := range jobs {
+		t := time.NewTimer(stopWait(j.value) + time.Second)
+		select {
+		case <-j.done:
+			t.Stop()
+		case <-t.C:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *daemon) isClosing() bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.closing
+}
+
+// release 实例退出时移除自身，不影响同一实例重新启动的新任务
+func (d *daemon) release(j *daemonJob) {
+	d.mux.Lock()
+	if i, ok := d.jobs[j.key()]; ok && i == j {
+		delete(d.jobs, j.key())
+	}
+	d.mux.Unlock()
+	if j.cancel != nil {
 		j.cancel()
-	} else {
-		d.mux.Unlock()
 	}
 }
 
+// reload 重载运行中的实例，instances为空时重载全部实例
+func (d *daemon) reload(v *model.Daemon, instances []uint, userID uint) []*proto.DaemonReloadResult {
+	want := make(map[uint]bool, len(instances))
+	for _, i := range instances {
+		want[i] = true
+	}
+	var jobs []*daemonJob
+	d.mux.Lock()
+	for k, j := range d.jobs {
+		if k.ID == v.ID && !j.stopping && (len(want) == 0 || want[k.Instance]) {
+			jobs = append(jobs, j)
+		}
+	}
+	d.mux.Unlock()
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].instance < jobs[k].instance
+	})
+	results := make([]*proto.DaemonReloadResult, 0, len(jobs))
+	for _, j := range jobs {
+		pid := int(j.pid.Load())
+		msg, err := j.reload()
+		j.reloadLog(pid, userID, msg, err)
+		r := &proto.DaemonReloadResult{DaemonID: v.ID, Name: v.Name, Instance: j.instance, Success: err == nil, Message: msg}
+		if err != nil {
+			r.Message = err.Error()
+		}
+		results = append(results, r)
+	}
+	if len(results) == 0 {
+		results = append(results, &proto.DaemonReloadResult{DaemonID: v.ID, Name: v.Name, Message: "任务未运行"})
+	}
+	return results
+}
+
 func (d *daemon) delAllJob() {
-	if len(d.jobs) == 0 {
+	d.mux.Lock()
+	for k, j := range d.jobs {
+		d.stopJob(k, j, model.DaemonTriggerSupervisor, 0)
+	}
+	d.mux.Unlock()
+}
+
+// recover 处理客户端退出前未停止的实例，允许保留时接管仍在运行的进程，否则结束后重新启动
+func (d *daemon) recover() {
+	// 停止过程中客户端退出的任务不再启动，残留的进程在下面结束
+	model.Task().Model(&model.Daemon{}).Where("status=?", model.StatusStopping).Updates(map[string]interface{}{
+		"status":   model.StatusStopped,
+		"end_time": uint(time.Now().Unix()),
+	})
+	var instances []*model.DaemonInstance
+	err := model.Task().Where("status in (?)", []string{model.StatusRunning, model.StatusRestarting, model.StatusWaiting, model.StatusCrashLoop, model.StatusStopping}).Order("daemon_id asc, instance asc").Find(&instances).Error
+	if err != nil || len(instances) == 0 {
+		return
+	}
+	IDS := make([]uint, 0, len(instances))
+	for _, i := range instances {
+		IDS = append(IDS, i.DaemonID)
+	}
+	var dj []*model.Daemon
+	err = model.Task().Where("id in (?) and status in (?)", IDS, []string{model.StatusRunning, model.StatusRestarting, model.StatusWaiting, model.StatusCrashLoop}).Find(&dj).Error
+	if err != nil {
 		return
 	}
+	byID := make(map[uint]*model.Daemon, len(dj))
+	for _, v := range dj {
+		byID[v.ID] = v
+	}
+	survive := config.DaemonSurviveRestart()
+	relaunch := make(map[uint][]uint)
+	for _, i := range instances {
+		alive := processAlive(i.Pid, i.ProcStartTime)
+		v, ok := byID[i.DaemonID]
+		if ok && i.Instance < numprocs(v) && alive && survive {
+			value := *v
+			if err := d.addJob(&daemonJob{value: &value, instance: i.Instance, adopt: i, trigger: model.DaemonTriggerSupervisor}); err != nil {
+				Zap.Sugar().Errorf("[Daemon]adopt %s#%d failed, %s", v.Name, i.Instance, err.Error())
+			}
+			continue
+		}
+		if alive {
+			_ = syscall.Kill(-i.Pid, syscall.SIGKILL)
+		}
+		if ok && i.Instance < numprocs(v) {
+			relaunch[v.ID] = append(relaunch[v.ID], i.Instance)
+			continue
+		}
+		model.Task().Model(i).Updates(map[string]interface{}{
+			"status":   model.StatusStopped,
+			"pid":      0,
+			"end_time": uint(time.Now().Unix()),
+		})
+	}
+	var list []*model.Daemon
+	for ID := range relaunch {
+		list = append(list, byID[ID])
+	}
+	list, err = orderDaemons(list)
+	if err != nil {
+		Zap.Sugar().Errorf("[Daemon]recover in priority order, %s", err.Error())
+	}
+	for _, v := range list {
+		_ = d.addDaemon(v, relaunch[v.ID], 0)
+	}
+}
+
+// detach 客户端退出时保留运行中的进程，只停止读取输出并记录位置，由重启后的客户端接管
+func (d *daemon) detach() {
 	d.mux.Lock()
-	for ID, j := range d.jobs {
-		delete(d.jobs, ID)
-		j.cancel()
+	d.closing = true
+	jobs := make([]*daemonJob, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		jobs = append(jobs, j)
 	}
 	d.mux.Unlock()
+	for _, j := range jobs {
+		j.closeSpools()
+	}
+}
+
+func (d *daemon) shutdown(timeout time.Duration) {
+	if config.DaemonSurviveRestart() {
+		d.detach()
+		return
+	}
+	d.mux.Lock()
+	d.closing = true
+	for _, j := range d.jobs {
+		// 至少等待各任务配置的停止时间
+		if w := stopWait(j.value) + time.Second; w > timeout {
+			timeout = w
+		}
+	}
+	d.mux.Unlock()
+	d.delAllJob()
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		Zap.Sugar().Errorln("[Daemon]stopped jobs did not finish in time")
+	}
 }
 
 func (d *daemon) run() {
 	for i := range d.ready {
 		d.mux.Lock()
-		if j, ok := d.jobs[i.value.ID]; ok {
+		if j, ok := d.jobs[i.key()]; ok && j == i && !j.stopping && !d.closing {
 			j.ctx, j.cancel = context.WithCancel(context.Background())
+			j.done = make(chan struct{})
+			d.wg.Add(1)
 			go j.exec()
 		}
 		d.mux.Unlock()
 	}
 }
 
+// syncStatus 多实例时按各实例状态汇总任务状态，任一实例运行即视为运行中，健康状态同时汇总
+func (d *daemon) syncStatus(ID uint) {
+	d.statusMux.Lock()
+	defer d.statusMux.Unlock()
+	var instances []*model.DaemonInstance
+	err := model.Task().Where("daemon_id=?", ID).Find(&instances).Error
+	if err != nil {
+		return
+	}
+	status := model.StatusStopped
+	var startTime, endTime, failed uint
+	var failedReason string
+	health, healthMsg, running := model.HealthHealthy, "", 0
+	for _, i := range instances {
+		if i.Status == model.StatusRunning {
+			running++
+			// 健康状态取各运行实例中最差的，任一实例不健康即不健康，尚未检查的实例使其为未知
+			switch {
+			case i.HealthStatus == model.HealthUnhealthy:
+				if health != model.HealthUnhealthy {
+					health, healthMsg = model.HealthUnhealthy, fmt.Sprintf("实例%d,%s", i.Instance, i.HealthMsg)
+				}
+			case i.HealthStatus != model.HealthHealthy && health == model.HealthHealthy:
+				health = model.HealthUnknown
+			}
+		}
+		switch i.Status {
+		case model.StatusCrashLoop:
+			// 任一实例频繁崩溃时不显示为运行中，避免掩盖问题
+			status = model.StatusCrashLoop
+		case model.StatusRunning:
+			if status != model.StatusCrashLoop {
+				status = model.StatusRunning
+			}
+		case model.StatusRestarting, model.StatusWaiting:
+			if status != model.StatusRunning && status != model.StatusCrashLoop {
+				status = i.Status
+			}
+		case model.StatusStopping:
+			if status == model.StatusStopped {
+				status = model.StatusStopping
+			}
+		case model.StatusStopped:
+			if i.EndTime > endTime {
+				endTime = i.EndTime
+			}
+			if i.Failed == 1 {
+				failed = 1
+				failedReason = fmt.Sprintf("实例%d,%s", i.Instance, i.FailedReason)
+			}
+		}
+		if i.Status != model.StatusStopped && i.StartTime > 0 && (startTime == 0 || i.StartTime < startTime) {
+			startTime = i.StartTime
+		}
+	}
+	if running == 0 {
+		health = model.HealthUnknown
+	}
+	if len(healthMsg) > 255 {
+		healthMsg = healthMsg[:255]
+	}
+	fields := map[string]interface{}{
+		"status":        status,
+		"health_status": health,
+		"health_msg":    healthMsg,
+	}
+	if status == model.StatusStopped {
+		fields["end_time"] = endTime
+		fields["failed"] = failed
+		fields["failed_reason"] = failedReason
+	} else {
+		fields["start_time"] = startTime
+	}
+	model.Task().Model(&model.Daemon{}).Where("id=? and status<>?", ID, model.StatusUnaudited).Updates(fields)
+}
+
+func (j *daemonJob) key() daemonKey {
+	return daemonKey{ID: j.value.ID, Instance: j.instance}
+}
+
+// updateInstance 只写入实例表，进程ID和输出读取位置不需要同步到任务
+func (j *daemonJob) updateInstance(fields map[string]interface{}) error {
+	return model.Task().Model(&model.DaemonInstance{}).Where("daemon_id=? and instance=?", j.value.ID, j.instance).Updates(fields).Error
+}
+
+// update 写入实例状态，单实例时同步写入任务本身，多实例时汇总到任务
+func (j *daemonJob) update(fields map[string]interface{}) error {
+	err := model.Task().Model(&model.DaemonInstance{}).Where("daemon_id=? and instance=?", j.value.ID, j.instance).Updates(fields).Error
+	if err != nil {
+		return err
+	}
+	if numprocs(j.value) == 1 {
+		// 停止等待期间任务可能已被修改为待审核，不再覆盖其状态
+		return model.Task().Model(j.value).Where("status<>?", model.StatusUnaudited).Updates(fields).Error
+	}
+	_, status := fields["status"]
+	_, health := fields["health_status"]
+	if status || health {
+		j.daemon.syncStatus(j.value.ID)
+	}
+	return nil
+}
+
 func (j *daemonJob) exec() {
+	defer j.daemon.wg.Done()
+	defer close(j.done)
 	j.sTime = time.Now()
-	err := model.Task().Model(j.value).Updates(map[string]interface{}{
-		"start_time": uint(time.Now().Unix()),
-		"status":     model.StatusRunning,
-	}).Error
+	instance := model.DaemonInstance{DaemonID: j.value.ID, Instance: j.instance}
+	err := model.Task().Where(map[string]interface{}{"daemon_id": j.value.ID, "instance": j.instance}).FirstOrCreate(&instance).Error
+	adopt := j.adopt
+	if err == nil && adopt != nil {
+		// 接管的进程保留原来的开启时间
+		err = j.update(map[string]interface{}{
+			"status":            model.StatusRunning,
+			"next_restart_time": 0,
+		})
+	} else if err == nil {
+		err = j.update(map[string]interface{}{
+			"start_time":        uint(time.Now().Unix()),
+			"status":            model.StatusRunning,
+			"retry_num":         0,
+			"next_restart_time": 0,
+		})
+	}
 	if err != nil {
-		Zap.Sugar().Errorf("%s update status failed during execution, err:%s \n", j.value.Name, err.Error())
+		Zap.Sugar().Errorf("%s update status failed during execution, err:%s \n", j.name(), err.Error())
+		j.daemon.release(j)
 		return
 	}
-	t := time.NewTicker(1 * time.Second)
+	failed := true
 	defer func() {
-		t.Stop()
 		if e := recover(); e != nil {
-			Zap.Sugar().Errorf("%s exec panic %s \n", j.value.Name, e)
-		}
-		j.daemon.delJob(j.value.ID)
-		model.Task().Model(j.value).Updates(map[string]interface{}{
-			"status":        model.StatusStopped,
-			"failed":        1,
-			"failed_reason": j.errMsg,
-			"end_time":      uint(time.Now().Unix()),
+			Zap.Sugar().Errorf("%s exec panic %s \n", j.name(), e)
+		}
+		j.daemon.release(j)
+		// 按计划停止不视为失败
+		if j.stopBy == model.DaemonTriggerSchedule {
+			failed = false
+		}
+		status := model.StatusStopped
+		if j.stopBy == model.DaemonTriggerSupervisor && j.daemon.isClosing() {
+			// 客户端退出时停止的实例保持待重启，由重启后的客户端在recover中重新启动
+			failed = false
+			status = model.StatusRestarting
+			j.errMsg = "客户端退出，重启后恢复运行"
+		}
+		j.addLog(&model.DaemonLog{
+			Event:    model.DaemonEventStop,
+			Trigger:  j.stopTrigger(),
+			Duration: time.Now().Sub(j.sTime).Seconds(),
+			Reason:   j.errMsg,
+			UserID:   j.stopUserID,
+		})
+		_ = j.update(map[string]interface{}{
+			"status":            status,
+			"failed":            helper.BoolToInt(failed),
+			"failed_reason":     j.errMsg,
+			"end_time":          uint(time.Now().Unix()),
+			"next_restart_time": 0,
 		})
-		j.failedNotice()
+		// 频繁崩溃时已经通知过，不再重复通知
+		if failed && !j.crashLoop.Load() {
+			j.failedNotice()
+		}
 	}()
+	if len(j.value.DependsOn) > 0 && adopt == nil {
+		_ = j.update(map[string]interface{}{"status": model.StatusWaiting})
+		err = j.waitDepends()
+		if j.ctx.Err() != nil {
+			j.errMsg = j.stopReason()
+			return
+		}
+		if err != nil {
+			j.errMsg = err.Error()
+			return
+		}
+		_ = j.update(map[string]interface{}{
+			"status":     model.StatusRunning,
+			"start_time": uint(time.Now().Unix()),
+		})
+	}
 	var retryNum uint = 0
 	for {
-		err = j.launch()
-		if err == nil {
-			j.errMsg = "脚本错误," + err.Error()
+		launchTime := time.Now()
+		stable := time.AfterFunc(crashLoopWindow(j.value), j.stabilised)
+		if adopt != nil {
+			err = j.attach(adopt)
+			adopt = nil
+		} else {
+			err = j.launch()
+		}
+		stable.Stop()
+		if j.ctx.Err() != nil {
+			j.errMsg = j.stopReason()
 			return
 		}
-		Zap.Sugar().Errorf("%s exec failed, err:%s \n", j.value.Name, err.Error())
-		retryNum++
-		select {
-		case <-j.ctx.Done():
-			j.errMsg = "人工干预停止"
+		if err != nil {
+			Zap.Sugar().Errorf("%s exec failed, err:%s \n", j.name(), err.Error())
+		}
+		var ue *unhealthyError
+		if errors.As(err, &ue) {
+			// 健康检查触发的重启不受重启策略和重试次数限制
+			if !j.wait(j.restartDelay(1), retryNum, model.DaemonTriggerHealth, ue.reason) {
+				return
+			}
+			continue
+		}
+		if !j.shouldRestart(err) {
+			if err == nil {
+				failed = false
+				j.errMsg = "进程正常退出"
+			} else {
+				j.errMsg = "进程异常退出," + err.Error()
+			}
 			return
-		case <-t.C:
 		}
-		if retryNum > j.value.FailedRestartNum {
+		if j.value.RestartResetAfter > 0 && time.Now().Sub(launchTime) >= time.Duration(j.value.RestartResetAfter)*time.Second {
+			retryNum = 0
+		}
+		retryNum++
+		if j.reachRestartLimit(retryNum) {
 			j.errMsg = "未开启错误重试或已达到最大重试次数"
+			if err != nil {
+				j.errMsg += "," + err.Error()
+			}
+			return
+		}
+		reason := "进程正常退出"
+		if err != nil {
+			reason = "进程异常退出," + err.Error()
+		}
+		if !j.wait(j.restartDelay(retryNum), retryNum, model.DaemonTriggerSupervisor, reason) {
 			return
 		}
 	}
 }
 
+// wait 记录重启原因并等待重启间隔，频繁崩溃时改用更长的间隔，期间被停止返回false
+func (j *daemonJob) wait(delay time.Duration, retryNum uint, trigger string, reason string) bool {
+	status := model.StatusRestarting
+	if j.recordRestart() {
+		status = model.StatusCrashLoop
+		delay = crashLoopBackoff(j.value)
+	}
+	j.trigger, j.userID = trigger, 0
+	j.addLog(&model.DaemonLog{
+		Event:   model.DaemonEventRestart,
+		Trigger: trigger,
+		Reason:  fmt.Sprintf("%s,%d秒后第%d次重启", reason, int(delay.Seconds()), retryNum),
+	})
+	_ = j.update(map[string]interface{}{
+		"status":            status,
+		"retry_num":         retryNum,
+		"failed_reason":     reason,
+		"next_restart_time": uint(time.Now().Add(delay).Unix()),
+	})
+	t := time.NewTimer(delay)
+	select {
+	case <-j.ctx.Done():
+		t.Stop()
+		j.errMsg = j.stopReason()
+		return false
+	case <-t.C:
+	}
+	// 频繁崩溃状态保持到进程稳定运行
+	if !j.crashLoop.Load() {
+		status = model.StatusRunning
+	}
+	_ = j.update(map[string]interface{}{
+		"status":            status,
+		"start_time":        uint(time.Now().Unix()),
+		"next_restart_time": 0,
+	})
+	return true
+}
+
+// shouldRestart 未配置重启策略时沿用失败重启的行为
+func (j *daemonJob) shouldRestart(err error) bool {
+	switch j.value.RestartPolicy {
+	case model.RestartAlways:
+		return true
+	case model.RestartNever:
+		return false
+	default:
+		return err != nil
+	}
+}
+
+// reachRestartLimit always策略未设置次数时一直重启，其余情况不超过失败重启次数
+func (j *daemonJob) reachRestartLimit(retryNum uint) bool {
+	if j.value.RestartPolicy == model.RestartAlways && j.value.FailedRestartNum == 0 {
+		return false
+	}
+	return retryNum > j.value.FailedRestartNum
+}
+
+// restartDelay 按重试次数指数退避，不超过最大间隔
+func (j *daemonJob) restartDelay(retryNum uint) time.Duration {
+	backoff := time.Duration(j.value.RestartBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	maxDelay := time.Duration(j.value.RestartMaxDelay) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Minute
+	}
+	delay := backoff
+	for i := uint(1); i < retryNum && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
 func (j *daemonJob) launch() error {
-	var (
-		err            error
-		stdout, stderr io.ReadCloser
-	)
-	command := j.value.Command
-	args := strings.Split(command, " ")
-	cmd := j.getCmd(args[0], args[1:]...)
-	stdout, err = cmd.StdoutPipe()
+	var err error
+	j.env, j.secrets, err = resolveSecretEnv(j.value.Env)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = stdout.Close()
-	}()
-	stderr, err = cmd.StderrPipe()
+	j.env = j.instanceEnv(j.env)
+	err = j.setLogFile()
 	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = stderr.Close()
+		_ = j.logFile.Close()
 	}()
+	if j.value.PreHook != "" {
+		var out []byte
+		ctx, cancel := hookContext(j.ctx)
+		out, err = runHook(ctx, j.value.PreHook, j.value.Dir, j.value.User, j.env)
+		cancel()
+		j.writeLog(proto.LogStreamHook, []byte(hookLog(hookPre, out, err)))
+		if err != nil {
+			return errors.New("前置钩子执行失败," + err.Error())
+		}
+	}
+	sTime := time.Now()
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+	command := j.value.Command
+	args := strings.Split(command, " ")
+	cmd := j.getCmd(ctx, args[0], args[1:]...)
+	survive := config.DaemonSurviveRestart()
+	var pipes []*daemonLogWriter
+	if survive {
+		// 输出写入暂存文件而不是管道，客户端退出后进程仍可继续输出
+		stdout, err := createSpool(config.DaemonSpoolPath(j.value.ID, j.instance, proto.LogStreamStdout))
+		if err != nil {
+			return err
+		}
+		defer stdout.Close()
+		stderr, err := createSpool(config.DaemonSpoolPath(j.value.ID, j.instance, proto.LogStreamStderr))
+		if err != nil {
+			return err
+		}
+		defer stderr.Close()
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	} else {
+		// 两个输出流由exec包各自的goroutine并发读取，Wait会等待读取结束，时间戳为输出时间
+		pipes = []*daemonLogWriter{{job: j, stream: proto.LogStreamStdout}, {job: j, stream: proto.LogStreamStderr}}
+		cmd.Stdout = pipes[0]
+		cmd.Stderr = pipes[1]
+	}
 	err = cmd.Start()
 	if err != nil {
 		return err
 	}
+	pid := cmd.Process.Pid
+	startTime, _ := procStartTime(pid)
+	_ = j.updateInstance(map[string]interface{}{
+		"pid":             pid,
+		"proc_start_time": startTime,
+		"stdout_offset":   0,
+		"stderr_offset":   0,
+	})
+	j.pid.Store(int64(pid))
+	defer j.exited()
+	j.addLog(&model.DaemonLog{Event: model.DaemonEventStart, Trigger: j.trigger, Pid: pid, UserID: j.userID})
+	if survive {
+		if err = j.tail(0, 0); err != nil {
+			Zap.Sugar().Errorf("%s read output failed, %s \n", j.name(), err.Error())
+		}
+	}
+	unhealthy := make(chan string, 1)
+	if j.healthEnabled() {
+		go j.healthCheck(ctx, cancel, unhealthy)
+	}
+	err = cmd.Wait()
+	j.closeSpools()
+	for _, w := range pipes {
+		w.flush()
+	}
+	select {
+	case reason := <-unhealthy:
+		err = &unhealthyError{reason: reason}
+		j.writeLog(proto.LogStreamHealth, []byte(reason))
+	default:
+	}
+	exitCode := j.exitLog(pid, cmd.ProcessState, err, time.Now().Sub(sTime))
+	j.postHook(exitCode, err, time.Now().Sub(sTime))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// attach 接管客户端重启前启动的进程，进程不是当前客户端的子进程，只能轮询判断是否退出，无法得到退出码
+func (j *daemonJob) attach(adopt *model.DaemonInstance) error {
+	var err error
+	j.env, j.secrets, err = resolveSecretEnv(j.value.Env)
+	if err != nil {
+		// 进程已在运行，密钥只用于日志脱敏和钩子，解析失败不影响接管
+		Zap.Sugar().Errorf("%s resolve secrets failed, %s \n", j.name(), err.Error())
+	}
+	j.env = j.instanceEnv(j.env)
+	pid := adopt.Pid
 	err = j.setLogFile()
 	if err != nil {
+		_ = syscall.Kill(-pid, syscall.SIGKILL)
 		return err
 	}
 	defer func() {
 		_ = j.logFile.Close()
 	}()
-	reader := bufio.NewReader(stdout)
-	readerErr := bufio.NewReader(stderr)
-	go func() {
-		var line []byte
-		for {
-			line, err = reader.ReadBytes('\n')
-			if err != nil || err == io.EOF {
-				break
-			}
-			j.writeLog(line)
-		}
-		for {
-			line, _ = readerErr.ReadBytes('\n')
-			if err != nil || err == io.EOF {
-				break
-			}
-			j.writeLog(line)
+	sTime := time.Unix(int64(adopt.StartTime), 0)
+	ctx, cancel := context.WithCancel(j.ctx)
+	defer cancel()
+	j.pid.Store(int64(pid))
+	defer j.exited()
+	err = j.tail(adopt.StdoutOffset, adopt.StderrOffset)
+	if err != nil {
+		Zap.Sugar().Errorf("%s read output failed, %s \n", j.name(), err.Error())
+	}
+	Zap.Sugar().Infof("%s adopted running process %d \n", j.name(), pid)
+	j.addLog(&model.DaemonLog{Event: model.DaemonEventStart, Trigger: j.trigger, Pid: pid, Reason: "接管客户端重启前启动的进程"})
+	unhealthy := make(chan string, 1)
+	if j.healthEnabled() {
+		go j.healthCheck(ctx, cancel, unhealthy)
+	}
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+	done := ctx.Done()
+	for processAlive(pid, adopt.ProcStartTime) {
+		select {
+		case <-done:
+			done = nil
+			_ = j.stopPid(pid)
+		case <-t.C:
 		}
-	}()
-	err = cmd.Wait()
+	}
+	j.closeSpools()
+	err = errors.New("进程已退出，退出码未知")
+	select {
+	case reason := <-unhealthy:
+		err = &unhealthyError{reason: reason}
+		j.writeLog(proto.LogStreamHealth, []byte(reason))
+	default:
+	}
+	j.exitLog(pid, nil, err, time.Now().Sub(sTime))
+	j.postHook(-1, err, time.Now().Sub(sTime))
+	return err
+}
+
+// exited 进程退出后清除进程ID和暂存文件
+func (j *daemonJob) exited() {
+	j.pid.Store(0)
+	j.closeSpools()
+	j.removeSpools()
+	_ = j.updateInstance(map[string]interface{}{"pid": 0})
+}
+
+func (j *daemonJob) postHook(exitCode int, err error, duration time.Duration) {
+	if j.value.PostHook == "" {
+		return
+	}
+	status := model.ExecStatusSuccess
 	if err != nil {
-		return err
+		status = model.ExecStatusError
 	}
-	return nil
+	env := hookOutcomeEnv(j.env, status, exitCode, duration)
+	ctx, cancel := hookContext(context.Background())
+	defer cancel()
+	out, hookErr := runHook(ctx, j.value.PostHook, j.value.Dir, j.value.User, env)
+	j.writeLog(proto.LogStreamHook, []byte(hookLog(hookPost, out, hookErr)))
 }
 
 func (j *daemonJob) setLogFile() error {
-	logPath := config.DaemonLogPath(j.value.ID, time.Now().Format(proto.LogPathTimeLayout))
+	logPath := config.DaemonInstanceLogPath(j.value.ID, j.instance, time.Now().Format(proto.LogPathTimeLayout))
+	return j.openLogFile(logPath)
+}
+
+func (j *daemonJob) openLogFile(logPath string) error {
 	logFile, err := helper.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_RDWR)
 	if err != nil {
 		return err
 	}
 	j.logFile = logFile
+	j.logPath = logPath
+	j.logSize = 0
+	if fi, err := logFile.Stat(); err == nil {
+		j.logSize = fi.Size()
+	}
 	return nil
 }
 
-func (j *daemonJob) writeLog(b []byte) {
-	logPath := config.DaemonLogPath(j.value.ID, time.Now().Format(proto.LogPathTimeLayout))
+// writeLog 写入钩子、健康检查等多行文本，空内容不记录
+func (j *daemonJob) writeLog(stream string, b []byte) {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	if len(b) == 0 {
+		return
+	}
+	j.writeLines(stream, bytes.Split(b, []byte("\n")))
+}
+
+// writeLines 每行带上时间和输出流标记后写入日志
+func (j *daemonJob) writeLines(stream string, lines [][]byte) {
+	prefix := time.Now().Format(proto.TimeMicroLayout) + " [" + stream + "] "
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if len(j.secrets) > 0 {
+			line = []byte(maskSecrets(string(line), j.secrets))
+		}
+		buf.WriteString(prefix)
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	j.logMux.Lock()
+	defer j.logMux.Unlock()
+	j.write(buf.Bytes())
+}
+
+func (j *daemonJob) write(b []byte) {
+	logPath := config.DaemonInstanceLogPath(j.value.ID, j.instance, time.Now().Format(proto.LogPathTimeLayout))
 	if logPath != j.logPath {
 		_ = j.logFile.Close()
-		var err error
-		j.logFile, err = helper.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_RDWR)
+		err := j.openLogFile(logPath)
 		if err != nil {
-			Zap.Sugar().Errorf("%s write log failed, %s \n", j.value.Name, err.Error())
+			Zap.Sugar().Errorf("%s write log failed, %s \n", j.name(), err.Error())
 			return
 		}
-		j.logPath = logPath
 	}
-	_, _ = j.logFile.Write(b)
+	n, _ := j.logFile.Write(b)
+	j.logSize += int64(n)
+	maxSize, _, _ := logPolicy(j.value)
+	if maxSize > 0 && j.logSize >= int64(maxSize)*1024*1024 {
+		j.rotate()
+	}
 }
 
-func (j *daemonJob) getCmd(name string, arg ...string) *exec.Cmd {
-	cmd := exec.CommandContext(j.ctx, name, arg...)
+// instanceEnv 追加实例序号，未配置环境变量时继承当前进程的环境变量
+func (j *daemonJob) instanceEnv(env []string) []string {
+	e := make([]string, 0, len(env)+2)
+	if len(env) == 0 {
+		e = append(e, os.Environ()...)
+	} else {
+		e = append(e, env...)
+	}
+	return append(e,
+		"TASK_INSTANCE_INDEX="+strconv.Itoa(int(j.instance)),
+		"TASK_NUMPROCS="+strconv.Itoa(int(numprocs(j.value))),
+	)
+}
+
+func (j *daemonJob) name() string {
+	if numprocs(j.value) == 1 {
+		return j.value.Name
+	}
+	return fmt.Sprintf("%s#%d", j.value.Name, j.instance)
+}
+
+func (j *daemonJob) getCmd(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Cancel = func() error {
+		return j.stopPid(cmd.Process.Pid)
+	}
+	// 停止信号发出后超过等待时间仍未退出时，由stopPid负责强制结束
+	cmd.WaitDelay = stopWait(j.value) + 5*time.Second
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 	cmd.SysProcAttr.Setsid = true
 	if j.value.Dir != "" && helper.FileExist(j.value.Dir) {
 		cmd.Dir = j.value.Dir
 	}
-	if len(j.value.Env) > 0 {
-		cmd.Env = j.value.Env
+	if len(j.env) > 0 {
+		cmd.Env = j.env
 	}
 	if j.value.User != "" {
 		user, err := user.Lookup(j.value.User)
 		if err == nil {
-			cmd.SysProcAttr = &syscall.SysProcAttr{}
 			uid, _ := strconv.Atoi(user.Uid)
 			gid, _ := strconv.Atoi(user.Gid)
 			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
@@ -250,15 +1028,78 @@ func (j *daemonJob) getCmd(name string, arg ...string) *exec.Cmd {
 	return cmd
 }
 
+// stopPid 向整个进程组发送停止信号，超过等待时间仍未退出时强制结束进程组
+func (j *daemonJob) stopPid(pgid int) error {
+	sig, _ := stopSignal(j.value.StopSignal)
+	err := syscall.Kill(-pgid, sig)
+	if sig == syscall.SIGKILL {
+		return err
+	}
+	go func() {
+		t := time.NewTicker(200 * time.Millisecond)
+		defer t.Stop()
+		deadline := time.Now().Add(stopWait(j.value))
+		for time.Now().Before(deadline) {
+			<-t.C
+			// 信号0只检查进程组是否还存在
+			if syscall.Kill(-pgid, 0) != nil {
+				return
+			}
+		}
+		Zap.Sugar().Warnf("%s did not stop within %s, killing process group \n", j.name(), stopWait(j.value))
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	}()
+	return err
+}
+
+// reload 有重载命令时执行命令，否则向主进程发送重载信号
+func (j *daemonJob) reload() (string, error) {
+	pid := int(j.pid.Load())
+	if pid == 0 {
+		return "", errors.New("进程未运行")
+	}
+	if j.value.ReloadCommand == "" {
+		sig, err := reloadSignal(j.value.ReloadSignal)
+		if err != nil {
+			return "", err
+		}
+		err = syscall.Kill(pid, sig)
+		msg := fmt.Sprintf("向进程%d发送信号%s", pid, sig)
+		if err != nil {
+			msg += "失败," + err.Error()
+		}
+		j.writeLog(proto.LogStreamReload, []byte(msg))
+		return msg, err
+	}
+	ctx, cancel := context.WithTimeout(j.ctx, time.Minute)
+	defer cancel()
+	env := append(append([]string(nil), j.env...), "TASK_PID="+strconv.Itoa(pid))
+	out, err := runHook(ctx, j.value.ReloadCommand, j.value.Dir, j.value.User, env)
+	j.writeLog(proto.LogStreamReload, []byte(hookLog("reload", out, err)))
+	// 结果会写入操作记录，只保留输出的开头部分
+	msg := []rune(strings.TrimSpace(string(out)))
+	if len(msg) > 60 {
+		msg = msg[:60]
+	}
+	if err != nil {
+		return string(msg), errors.New("重载命令执行失败," + err.Error())
+	}
+	return string(msg), nil
+}
+
 func (j *daemonJob) failedNotice() {
+	j.notice("任务失败", "出错", j.errMsg)
+}
+
+func (j *daemonJob) notice(subject string, kind string, reason string) {
 	if len(j.value.FailedNotice) == 0 {
 		return
 	}
 	for _, notice := range j.value.FailedNotice {
 		switch notice {
 		case model.DingTalkNotify:
-			title := config.NodeAddr() + "告警：任务失败"
-			content := fmt.Sprintf("> ###### 节点: %s 的任务出错报警：\n> ##### 任务ID：%d\n> ##### 任务名称：%s\n> ##### 报警时间：%s> ##### 失败原因:%s\n", config.NodeAddr(), int(j.value.ID), j.value.Name, time.Now().Format(proto.TimeLayout), j.errMsg)
+			title := config.NodeAddr() + "告警：" + subject
+			content := fmt.Sprintf("> ###### 节点: %s 的任务%s报警：\n> ##### 任务ID：%d\n> ##### 任务名称：%s\n> ##### 报警时间：%s> ##### 失败原因:%s\n", config.NodeAddr(), kind, int(j.value.ID), j.name(), time.Now().Format(proto.TimeLayout), reason)
 			args := &proto.DingTalkNoticeArgs{
 				Address: j.value.DingTalkAddr,
 				Body: fmt.Sprintf(
@@ -273,7 +1114,7 @@ func (j *daemonJob) failedNotice() {
 			var reply bool
 			err := mrpc.Call(config.ManageListenAddr(), "Serve.DingTalkNotice", context.TODO(), args, &reply)
 			if err != nil {
-				Zap.Sugar().Errorln("任务失败-钉钉通知失败," + err.Error())
+				Zap.Sugar().Errorln(subject + "-钉钉通知失败," + err.Error())
 			}
 			break
 		default:
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+	"task/model"
+	"time"
+)
+
+func crashLoopRestarts(v *model.Daemon) int {
+	if v.CrashLoopRestarts == 0 {
+		return 5
+	}
+	return int(v.CrashLoopRestarts)
+}
+
+func crashLoopWindow(v *model.Daemon) time.Duration {
+	if v.CrashLoopWindow == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(v.CrashLoopWindow) * time.Second
+}
+
+func crashLoopBackoff(v *model.Daemon) time.Duration {
+	if v.CrashLoopBackoff == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(v.CrashLoopBackoff) * time.Second
+}
+
+// recordRestart 记录一次重启，窗口内重启次数达到阈值时进入频繁崩溃状态并只通知一次，返回是否处于频繁崩溃状态
+func (j *daemonJob) recordRestart() bool {
+	now := time.Now()
+	window := crashLoopWindow(j.value)
+	restarts := j.restarts[:0]
+	for _, t := range j.restarts {
+		if now.Sub(t) < window {
+			restarts = append(restarts, t)
+		}
+	}
+	j.restarts = append(restarts, now)
+	if j.crashLoop.Load() || len(j.restarts) < crashLoopRestarts(j.value) {
+		return j.crashLoop.Load()
+	}
+	j.crashLoop.Store(true)
+	reason := fmt.Sprintf("%d秒内重启%d次，之后每%d秒重启一次，稳定运行%d秒后恢复", int(window.Seconds()), len(j.restarts), int(crashLoopBackoff(j.value).Seconds()), int(window.Seconds()))
+	Zap.Sugar().Warnf("%s crash loop, %s \n", j.name(), reason)
+	j.notice("任务频繁崩溃", "频繁崩溃", reason)
+	return true
+}
+
+// stabilised 进程持续运行超过窗口时间后恢复为运行中
+func (j *daemonJob) stabilised() {
+	if !j.crashLoop.CompareAndSwap(true, false) {
+		return
+	}
+	Zap.Sugar().Infof("%s recovered from crash loop \n", j.name())
+	_ = j.update(map[string]interface{}{
+		"status":        model.StatusRunning,
+		"failed_reason": "",
+	})
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"task/model"
+	"testing"
+	"time"
+)
+
+func TestCrashLoopPolicy(t *testing.T) {
+	v := &model.Daemon{}
+	if crashLoopRestarts(v) != 5 || crashLoopWindow(v) != 5*time.Minute || crashLoopBackoff(v) != 5*time.Minute {
+		t.Fatal("unexpected default crash loop policy")
+	}
+	v = &model.Daemon{CrashLoopRestarts: 3, CrashLoopWindow: 60, CrashLoopBackoff: 120}
+	if crashLoopRestarts(v) != 3 || crashLoopWindow(v) != time.Minute || crashLoopBackoff(v) != 2*time.Minute {
+		t.Fatal("unexpected crash loop policy")
+	}
+}
+
+func TestRecordRestart(t *testing.T) {
+	v := &model.Daemon{ID: 97, Name: "crash", Status: model.StatusCrashLoop, FailedReason: "exit status 1", CrashLoopRestarts: 3, CrashLoopWindow: 60}
+	if err := model.Task().Create(v).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		model.Task().Delete(v)
+	})
+	j := &daemonJob{value: v}
+	// 窗口之外的重启不计入
+	j.restarts = []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(-90 * time.Second)}
+	for i, want := range []bool{false, false, true, true} {
+		if got := j.recordRestart(); got != want {
+			t.Fatalf("restart %d: got %v, want %v", i+1, got, want)
+		}
+	}
+	if len(j.restarts) != 4 {
+		t.Fatalf("got %d restarts in window, want 4", len(j.restarts))
+	}
+	j.stabilised()
+	if j.crashLoop.Load() {
+		t.Fatal("should recover after running stably")
+	}
+	var got model.Daemon
+	model.Task().First(&got, v.ID)
+	if got.Status != model.StatusRunning || got.FailedReason != "" {
+		t.Fatalf("got status %s failed reason %q", got.Status, got.FailedReason)
+	}
+	// 未处于频繁崩溃状态时不更新
+	model.Task().Model(v).Update("status", model.StatusRestarting)
+	j.stabilised()
+	model.Task().First(&got, v.ID)
+	if got.Status != model.StatusRestarting {
+		t.Fatalf("got status %s, want %s", got.Status, model.StatusRestarting)
+	}
+	// 恢复后重新计算窗口内的重启次数
+	j.restarts = nil
+	if j.recordRestart() {
+		t.Fatal("first restart after recovery should not be a crash loop")
+	}
+}
+
+func TestSyncStatusCrashLoop(t *testing.T) {
+	v := &model.Daemon{ID: 98, Name: "crash instances", Numprocs: 3, Status: model.StatusRunning}
+	if err := model.Task().Create(v).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		model.Task().Where("daemon_id=?", v.ID).Delete(&model.DaemonInstance{})
+		model.Task().Delete(v)
+	})
+	for i, status := range []string{model.StatusRunning, model.StatusCrashLoop, model.StatusRestarting} {
+		if err := model.Task().Create(&model.DaemonInstance{DaemonID: v.ID, Instance: uint(i), Status: status, StartTime: 100}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	newDaemon().syncStatus(v.ID)
+	var got model.Daemon
+	model.Task().First(&got, v.ID)
+	if got.Status != model.StatusCrashLoop {
+		t.Fatalf("got status %s, want %s", got.Status, model.StatusCrashLoop)
+	}
+}
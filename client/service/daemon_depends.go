@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"task/model"
+	"time"
+)
+
+// orderDaemons 按依赖关系排序，无依赖关系的任务按启动优先级和ID排序，只考虑列表内的任务之间的依赖，
+// 存在循环时循环内的任务按启动优先级和ID排在最后，与错误一起返回，调用方可以记录后继续启动
+func orderDaemons(list []*model.Daemon) ([]*model.Daemon, error) {
+	byID := make(map[uint]*model.Daemon, len(list))
+	for _, v := range list {
+		byID[v.ID] = v
+	}
+	inDegree := make(map[uint]int, len(list))
+	dependents := make(map[uint][]*model.Daemon)
+	for _, v := range list {
+		for _, dep := range v.DependsOn {
+			if _, ok := byID[dep]; ok && dep != v.ID {
+				inDegree[v.ID]++
+				dependents[dep] = append(dependents[dep], v)
+			}
+		}
+	}
+	var ready []*model.Daemon
+	for _, v := range list {
+		if inDegree[v.ID] == 0 {
+			ready = append(ready, v)
+		}
+	}
+	ordered := make([]*model.Daemon, 0, len(list))
+	for len(ready) > 0 {
+		sortByPriority(ready)
+		v := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, v)
+		for _, d := range dependents[v.ID] {
+			inDegree[d.ID]--
+			if inDegree[d.ID] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(ordered) < len(list) {
+		var names []string
+		var blocked []*model.Daemon
+		for _, v := range list {
+			if inDegree[v.ID] > 0 {
+				names = append(names, v.Name)
+				blocked = append(blocked, v)
+			}
+		}
+		sortByPriority(blocked)
+		return append(ordered, blocked...), errors.New("依赖存在循环：" + strings.Join(names, ","))
+	}
+	return ordered, nil
+}
+
+func sortByPriority(list []*model.Daemon) {
+	sort.Slice(list, func(i, k int) bool {
+		if list[i].StartPriority != list[k].StartPriority {
+			return list[i].StartPriority < list[k].StartPriority
+		}
+		return list[i].ID < list[k].ID
+	})
+}
+
+// checkDepends 校验依赖的任务存在且加入后不形成循环
+func checkDepends(v *model.Daemon) error {
+	if len(v.DependsOn) == 0 {
+		return nil
+	}
+	if v.DependsCondition != "" && v.DependsCondition != model.DependsRunning && v.DependsCondition != model.DependsHealthy {
+		return errors.New("依赖就绪条件不合法")
+	}
+	var all []*model.Daemon
+	err := model.Task().Select("id", "name", "depends_on").Find(&all).Error
+	if err != nil {
+		return err
+	}
+	graph := make(map[uint][]uint, len(all))
+	names := make(map[uint]string, len(all))
+	for _, i := range all {
+		graph[i.ID] = i.DependsOn
+		names[i.ID] = i.Name
+	}
+	for _, dep := range v.DependsOn {
+		if dep == v.ID {
+			return errors.New("不能依赖自身")
+		}
+		if _, ok := names[dep]; !ok {
+			return fmt.Errorf("依赖的任务%d不存在", dep)
+		}
+	}
+	if v.ID == 0 {
+		// 新增的任务还不会被其他任务依赖
+		return nil
+	}
+	graph[v.ID] = v.DependsOn
+	names[v.ID] = v.Name
+	path := []uint{v.ID}
+	visited := make(map[uint]bool)
+	var dfs func(ID uint) bool
+	dfs = func(ID uint) bool {
+		for _, dep := range graph[ID] {
+			if dep == v.ID {
+				path = append(path, dep)
+				return true
+			}
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			path = append(path, dep)
+			if dfs(dep) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if dfs(v.ID) {
+		cycle := make([]string, 0, len(path))
+		for _, ID := range path {
+			cycle = append(cycle, names[ID])
+		}
+		return errors.New("依赖存在循环：" + strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// waitDepends 等待依赖的任务就绪，超时后启动失败
+func (j *daemonJob) waitDepends() error {
+	timeout := time.Duration(j.value.DependsTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	deadline := time.Now().Add(timeout)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		pending, err := j.pendingDepends()
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("依赖的任务未就绪：" + strings.Join(pending, ","))
+		}
+		select {
+		case <-j.ctx.Done():
+			return j.ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// pendingDepends 返回未就绪的依赖任务名，healthy条件下未配置健康检查的任务运行即视为就绪
+func (j *daemonJob) pendingDepends() ([]string, error) {
+	var deps []*model.Daemon
+	err := model.Task().Where("id in (?)", []uint(j.value.DependsOn)).Find(&deps).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(deps) < len(j.value.DependsOn) {
+		return nil, errors.New("依赖的任务不存在")
+	}
+	var pending []string
+	for _, dep := range deps {
+		if dep.Status != model.StatusRunning {
+			pending = append(pending, dep.Name)
+			continue
+		}
+		if j.value.DependsCondition != model.DependsHealthy || dep.HealthCheck == "" {
+			continue
+		}
+		var healthy int64
+		model.Task().Model(&model.DaemonInstance{}).Where("daemon_id=? and status=? and health_status=?", dep.ID, model.StatusRunning, model.HealthHealthy).Count(&healthy)
+		if healthy == 0 {
+			pending = append(pending, dep.Name)
+		}
+	}
+	return pending, nil
+}
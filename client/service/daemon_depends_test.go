@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"task/model"
+	"testing"
+)
+
+func TestOrderDaemons(t *testing.T) {
+	type daemon struct {
+		ID        uint
+		priority  int
+		dependsOn []uint
+	}
+	cases := []struct {
+		name    string
+		list    []daemon
+		want    []uint
+		wantErr bool
+	}{
+		{"priority then ID", []daemon{{3, 0, nil}, {1, 5, nil}, {2, 0, nil}}, []uint{2, 3, 1}, false},
+		{"dependency before priority", []daemon{{1, 0, []uint{2}}, {2, 9, nil}}, []uint{2, 1}, false},
+		{"chain", []daemon{{1, 0, []uint{2}}, {2, 0, []uint{3}}, {3, 0, nil}}, []uint{3, 2, 1}, false},
+		{"ready daemons by priority", []daemon{{1, 0, nil}, {2, 5, []uint{1}}, {3, 1, []uint{1}}, {4, 2, nil}}, []uint{1, 3, 4, 2}, false},
+		{"dependency outside the list", []daemon{{1, 1, []uint{99}}, {2, 0, nil}}, []uint{2, 1}, false},
+		{"self dependency ignored", []daemon{{1, 0, []uint{1}}, {2, 0, nil}}, []uint{1, 2}, false},
+		{"cycle kept in priority order", []daemon{{1, 2, []uint{2}}, {2, 1, []uint{1}}, {3, 5, nil}}, []uint{3, 2, 1}, true},
+		{"dependent of cycle", []daemon{{1, 0, []uint{2}}, {2, 0, []uint{1}}, {3, 0, []uint{1}}, {4, 9, nil}}, []uint{4, 1, 2, 3}, true},
+	}
+	for _, c := range cases {
+		list := make([]*model.Daemon, 0, len(c.list))
+		for _, v := range c.list {
+			list = append(list, &model.Daemon{ID: v.ID, Name: fmt.Sprintf("d%d", v.ID), StartPriority: v.priority, DependsOn: v.dependsOn})
+		}
+		ordered, err := orderDaemons(list)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: got error %v", c.name, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "依赖存在循环") {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+		var got []uint
+		for _, v := range ordered {
+			got = append(got, v.ID)
+		}
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		for k := range got {
+			if got[k] != c.want[k] {
+				t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+			}
+		}
+	}
+}
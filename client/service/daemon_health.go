@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"task/model"
+	"time"
+)
+
+// unhealthyError 健康检查连续失败后主动结束进程时由launch返回
+type unhealthyError struct {
+	reason string
+}
+
+func (e *unhealthyError) Error() string {
+	return e.reason
+}
+
+func (j *daemonJob) healthEnabled() bool {
+	switch j.value.HealthCheck {
+	case model.HealthCheckHttp, model.HealthCheckTcp:
+		return j.value.HealthPort > 0
+	case model.HealthCheckExec:
+		return j.value.HealthCommand != ""
+	default:
+		return false
+	}
+}
+
+// healthCheck 按间隔探测进程健康状态，连续失败达到阈值后通过kill结束进程并上报原因
+func (j *daemonJob) healthCheck(ctx context.Context, kill context.CancelFunc, unhealthy chan<- string) {
+	interval := time.Duration(j.value.HealthInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	timeout := time.Duration(j.value.HealthTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	threshold := j.value.HealthThreshold
+	if threshold == 0 {
+		threshold = 3
+	}
+	j.setHealth(model.HealthUnknown, "")
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var failures uint
+	status := model.HealthUnknown
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		err := j.probe(ctx, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			failures = 0
+			if status != model.HealthHealthy {
+				status = model.HealthHealthy
+				j.setHealth(status, "")
+			}
+			continue
+		}
+		failures++
+		status = model.HealthUnhealthy
+		j.setHealth(status, err.Error())
+		if failures >= threshold {
+			reason := fmt.Sprintf("健康检查连续%d次失败,%s", failures, err.Error())
+			Zap.Sugar().Warnf("%s unhealthy, restarting: %s \n", j.name(), reason)
+			unhealthy <- reason
+			kill()
+			return
+		}
+	}
+}
+
+func (j *daemonJob) probe(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addr := fmt.Sprintf("127.0.0.1:%d", j.value.HealthPort)
+	switch j.value.HealthCheck {
+	case model.HealthCheckHttp:
+		path := j.value.HealthPath
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("http状态码%d", resp.StatusCode)
+		}
+		return nil
+	case model.HealthCheckTcp:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case model.HealthCheckExec:
+		out, err := runHook(ctx, j.value.HealthCommand, j.value.Dir, j.value.User, j.env)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.New("检查超时")
+			}
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				return fmt.Errorf("%s,%s", err.Error(), msg)
+			}
+			return err
+		}
+		return nil
+	}
+	return nil
+}
+
+func (j *daemonJob) setHealth(status string, msg string) {
+	if len(msg) > 255 {
+		msg = msg[:255]
+	}
+	_ = j.update(map[string]interface{}{
+		"health_status": status,
+		"health_msg":    msg,
+	})
+}
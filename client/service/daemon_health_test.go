@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task/model"
+	"testing"
+	"time"
+)
+
+func listenPort(t *testing.T, l net.Listener) uint {
+	t.Helper()
+	return uint(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestHealthEnabled(t *testing.T) {
+	cases := []struct {
+		name  string
+		value *model.Daemon
+		want  bool
+	}{
+		{"none", &model.Daemon{}, false},
+		{"http", &model.Daemon{HealthCheck: model.HealthCheckHttp, HealthPort: 8080}, true},
+		{"http without port", &model.Daemon{HealthCheck: model.HealthCheckHttp}, false},
+		{"tcp", &model.Daemon{HealthCheck: model.HealthCheckTcp, HealthPort: 8080}, true},
+		{"exec", &model.Daemon{HealthCheck: model.HealthCheckExec, HealthCommand: "true"}, true},
+		{"exec without command", &model.Daemon{HealthCheck: model.HealthCheckExec}, false},
+	}
+	for _, c := range cases {
+		if got := (&daemonJob{value: c.value}).healthEnabled(); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	httpPort := listenPort(t, srv.Listener)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := listenPort(t, closed)
+	_ = closed.Close()
+	cases := []struct {
+		name    string
+		value   *model.Daemon
+		wantErr string
+	}{
+		{"http ok", &model.Daemon{HealthCheck: model.HealthCheckHttp, HealthPort: httpPort, HealthPath: "ok"}, ""},
+		{"http status", &model.Daemon{HealthCheck: model.HealthCheckHttp, HealthPort: httpPort, HealthPath: "/bad"}, "http状态码500"},
+		{"http refused", &model.Daemon{HealthCheck: model.HealthCheckHttp, HealthPort: closedPort}, "connection refused"},
+		{"tcp ok", &model.Daemon{HealthCheck: model.HealthCheckTcp, HealthPort: httpPort}, ""},
+		{"tcp refused", &model.Daemon{HealthCheck: model.HealthCheckTcp, HealthPort: closedPort}, "connection refused"},
+		{"exec ok", &model.Daemon{HealthCheck: model.HealthCheckExec, HealthCommand: "true"}, ""},
+		{"exec failed", &model.Daemon{HealthCheck: model.HealthCheckExec, HealthCommand: "false"}, "exit status 1"},
+		{"exec timeout", &model.Daemon{HealthCheck: model.HealthCheckExec, HealthCommand: "sleep 5"}, "检查超时"},
+	}
+	for _, c := range cases {
+		err := (&daemonJob{value: c.value}).probe(context.Background(), 500*time.Millisecond)
+		if c.wantErr == "" && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Fatalf("%s: got %v, want %s", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	cases := []struct {
+		name       string
+		value      *model.Daemon
+		wantStatus string
+		wantReason string
+	}{
+		{"healthy", &model.Daemon{ID: 51, HealthCheck: model.HealthCheckTcp, HealthPort: listenPort(t, l), HealthInterval: 1}, model.HealthHealthy, ""},
+		{"restart after threshold", &model.Daemon{ID: 52, HealthCheck: model.HealthCheckExec, HealthCommand: "false", HealthInterval: 1, HealthThreshold: 2}, model.HealthUnhealthy, "健康检查连续2次失败,exit status 1"},
+	}
+	for _, c := range cases {
+		if err = model.Task().Create(c.value).Error; err != nil {
+			t.Fatal(err)
+		}
+		j := &daemonJob{daemon: newDaemon(), value: c.value}
+		ctx, cancel := context.WithCancel(context.Background())
+		killed := make(chan struct{})
+		unhealthy := make(chan string, 1)
+		done := make(chan struct{})
+		go func() {
+			j.healthCheck(ctx, func() { close(killed) }, unhealthy)
+			close(done)
+		}()
+		var v model.Daemon
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			model.Task().First(&v, c.value.ID)
+			if v.HealthStatus == c.wantStatus && (c.wantReason == "" || len(unhealthy) > 0) {
+				break
+			}
+		}
+		if c.wantReason == "" {
+			cancel()
+			<-done
+		} else {
+			<-done
+			cancel()
+		}
+		if v.HealthStatus != c.wantStatus {
+			t.Fatalf("%s: got health status %q", c.name, v.HealthStatus)
+		}
+		select {
+		case reason := <-unhealthy:
+			<-killed
+			if reason != c.wantReason {
+				t.Fatalf("%s: got reason %s, want %s", c.name, reason, c.wantReason)
+			}
+		default:
+			if c.wantReason != "" {
+				t.Fatalf("%s: process should be killed", c.name)
+			}
+		}
+		model.Task().Delete(c.value)
+	}
+}
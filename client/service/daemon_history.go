@@ -0,0 +1,103 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"task/model"
+	"time"
+)
+
+func startTrigger(userID uint) string {
+	if userID > 0 {
+		return model.DaemonTriggerUser
+	}
+	return model.DaemonTriggerSupervisor
+}
+
+// stopTrigger 未被停止而自行结束时记录为守护触发
+func (j *daemonJob) stopTrigger() string {
+	if j.stopBy == "" {
+		return model.DaemonTriggerSupervisor
+	}
+	return j.stopBy
+}
+
+func (j *daemonJob) stopReason() string {
+	if j.stopBy == model.DaemonTriggerSchedule {
+		return "按计划停止"
+	}
+	return "人工干预停止"
+}
+
+// signalName 与停止信号的配置格式一致，不带SIG前缀
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// addLog 记录实例的一次生命周期事件，写入失败不影响任务运行
+func (j *daemonJob) addLog(l *model.DaemonLog) {
+	l.DaemonID = j.value.ID
+	l.Instance = j.instance
+	l.CreateTime = uint(time.Now().Unix())
+	err := model.Task().Create(l).Error
+	if err != nil {
+		Zap.Sugar().Errorf("%s add history failed, %s \n", j.name(), err.Error())
+	}
+}
+
+// exitLog 记录进程退出，state为空时退出码未知，返回退出码
+func (j *daemonJob) exitLog(pid int, state *os.ProcessState, err error, duration time.Duration) int {
+	l := &model.DaemonLog{
+		Event:    model.DaemonEventExit,
+		Trigger:  model.DaemonTriggerSupervisor,
+		Pid:      pid,
+		ExitCode: -1,
+		Duration: duration.Seconds(),
+		Reason:   "进程正常退出",
+	}
+	if state != nil {
+		l.ExitCode = state.ExitCode()
+		if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			l.Signal = signalName(ws.Signal())
+		}
+	}
+	if err != nil {
+		l.Reason = err.Error()
+	}
+	var ue *unhealthyError
+	if errors.As(err, &ue) {
+		l.Trigger = model.DaemonTriggerHealth
+		l.Reason = ue.reason
+	} else if j.ctx.Err() != nil {
+		l.Trigger = j.stopTrigger()
+		l.UserID = j.stopUserID
+		l.Reason = j.stopReason()
+	}
+	j.addLog(l)
+	return l.ExitCode
+}
+
+// reloadLog 记录实例的一次重载及其结果，msg为发送的信号或重载命令输出的开头部分
+func (j *daemonJob) reloadLog(pid int, userID uint, msg string, err error) {
+	l := &model.DaemonLog{
+		Event:   model.DaemonEventReload,
+		Trigger: startTrigger(userID),
+		Pid:     pid,
+		UserID:  userID,
+		Reason:  "重载成功",
+	}
+	if err != nil {
+		l.Reason = "重载失败," + err.Error()
+	}
+	if msg != "" {
+		l.Reason += "," + msg
+	}
+	j.addLog(l)
+}
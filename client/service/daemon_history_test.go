@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"task/model"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestExitLog(t *testing.T) {
+	t.Cleanup(func() {
+		model.Task().Where("daemon_id=?", 99).Delete(&model.DaemonLog{})
+	})
+	cases := []struct {
+		name        string
+		script      string
+		err         error
+		wantCode    int
+		wantSignal  string
+		wantTrigger string
+		wantReason  string
+	}{
+		{"exited", "exit 0", nil, 0, "", model.DaemonTriggerSupervisor, "进程正常退出"},
+		{"failed", "exit 3", errors.New("exit status 3"), 3, "", model.DaemonTriggerSupervisor, "exit status 3"},
+		{"killed", "kill -KILL $$", errors.New("signal: killed"), -1, "KILL", model.DaemonTriggerSupervisor, "signal: killed"},
+		{"unhealthy", "kill -TERM $$", &unhealthyError{reason: "健康检查连续3次失败"}, -1, "TERM", model.DaemonTriggerHealth, "健康检查连续3次失败"},
+		{"unknown", "", errors.New("进程已退出，退出码未知"), -1, "", model.DaemonTriggerSupervisor, "进程已退出，退出码未知"},
+	}
+	for _, c := range cases {
+		j := &daemonJob{value: &model.Daemon{ID: 99, Name: c.name}, instance: 1, ctx: context.Background()}
+		pid := 0
+		var code int
+		if c.script != "" {
+			cmd := exec.Command("sh", "-c", c.script)
+			_ = cmd.Run()
+			pid = cmd.Process.Pid
+			code = j.exitLog(pid, cmd.ProcessState, c.err, 2*time.Second)
+		} else {
+			code = j.exitLog(pid, nil, c.err, 2*time.Second)
+		}
+		if code != c.wantCode {
+			t.Fatalf("%s: got exit code %d, want %d", c.name, code, c.wantCode)
+		}
+		var l model.DaemonLog
+		model.Task().Where("daemon_id=?", 99).Order("id desc").First(&l)
+		if l.Event != model.DaemonEventExit || l.Instance != 1 || l.Pid != pid || l.ExitCode != c.wantCode || l.Signal != c.wantSignal ||
+			l.Trigger != c.wantTrigger || l.Reason != c.wantReason || l.Duration != 2 || l.CreateTime == 0 {
+			t.Fatalf("%s: got %+v", c.name, l)
+		}
+	}
+	// 被停止时记录停止原因而不是退出错误
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	j := &daemonJob{value: &model.Daemon{ID: 99, Name: "stopped"}, ctx: ctx}
+	j.exitLog(1, nil, errors.New("signal: terminated"), time.Second)
+	var l model.DaemonLog
+	model.Task().Where("daemon_id=?", 99).Order("id desc").First(&l)
+	if l.Trigger != model.DaemonTriggerSupervisor || l.Reason != "人工干预停止" {
+		t.Fatalf("stopped: got %+v", l)
+	}
+}
+
+func TestDaemonHistory(t *testing.T) {
+	t.Cleanup(func() {
+		model.Task().Where("daemon_id in (?)", []uint{100, 101}).Delete(&model.DaemonLog{})
+	})
+	logs := []*model.DaemonLog{
+		{DaemonID: 100, Instance: 0, Event: model.DaemonEventStart, CreateTime: 100},
+		{DaemonID: 100, Instance: 0, Event: model.DaemonEventExit, CreateTime: 200},
+		{DaemonID: 100, Instance: 1, Event: model.DaemonEventStart, CreateTime: 300},
+		{DaemonID: 100, Instance: 1, Event: model.DaemonEventRestart, CreateTime: 400},
+		{DaemonID: 100, Instance: 1, Event: model.DaemonEventStart, CreateTime: 500},
+		{DaemonID: 101, Instance: 0, Event: model.DaemonEventStart, CreateTime: 600},
+	}
+	if err := model.Task().Create(logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	instance := uint(1)
+	cases := []struct {
+		name      string
+		args      proto.DaemonHistoryArgs
+		wantTotal int64
+		wantTimes []uint
+	}{
+		{"all", proto.DaemonHistoryArgs{DaemonID: 100}, 5, []uint{500, 400, 300, 200, 100}},
+		{"page", proto.DaemonHistoryArgs{DaemonID: 100, Pagination: proto.Pagination{Page: 2, PageSize: 2}}, 5, []uint{300, 200}},
+		{"instance", proto.DaemonHistoryArgs{DaemonID: 100, Instance: &instance}, 3, []uint{500, 400, 300}},
+		{"event", proto.DaemonHistoryArgs{DaemonID: 100, Event: model.DaemonEventStart}, 3, []uint{500, 300, 100}},
+		{"time range", proto.DaemonHistoryArgs{DaemonID: 100, StartTime: 200, EndTime: 400}, 3, []uint{400, 300, 200}},
+		{"none", proto.DaemonHistoryArgs{DaemonID: 102}, 0, nil},
+	}
+	ds := &DaemonServe{}
+	for _, c := range cases {
+		if c.args.Page == 0 {
+			c.args.Pagination = proto.Pagination{Page: 1, PageSize: 10}
+		}
+		var reply proto.DaemonHistoryReply
+		if err := ds.History(&c.args, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Total != c.wantTotal || len(reply.List) != len(c.wantTimes) {
+			t.Fatalf("%s: got total %d, %d logs", c.name, reply.Total, len(reply.List))
+		}
+		for i, l := range reply.List {
+			if l.CreateTime != c.wantTimes[i] {
+				t.Fatalf("%s: log %d got create time %d, want %d", c.name, i, l.CreateTime, c.wantTimes[i])
+			}
+		}
+	}
+}
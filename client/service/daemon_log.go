@@ -0,0 +1,773 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"time"
+)
+
+// daemonLogMaxLine 单行日志的长度上限，超过后拆分为多行记录
+const daemonLogMaxLine = 64 * 1024
+
+// daemonLogWriter 将进程的一个输出流按行写入日志
+type daemonLogWriter struct {
+	job    *daemonJob
+	stream string
+	buf    []byte
+}
+
+func (w *daemonLogWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	var lines [][]byte
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.buf = append(w.buf, p...)
+			break
+		}
+		line := append(w.buf, p[:i]...)
+		lines = append(lines, w.split(line)...)
+		w.buf = nil
+		p = p[i+1:]
+	}
+	for len(w.buf) >= daemonLogMaxLine {
+		lines = append(lines, w.buf[:daemonLogMaxLine])
+		w.buf = append([]byte(nil), w.buf[daemonLogMaxLine:]...)
+	}
+	if len(lines) > 0 {
+		w.job.writeLines(w.stream, lines)
+	}
+	return n, nil
+}
+
+func (w *daemonLogWriter) split(line []byte) [][]byte {
+	lines := make([][]byte, 0, len(line)/daemonLogMaxLine+1)
+	for len(line) > daemonLogMaxLine {
+		lines = append(lines, line[:daemonLogMaxLine])
+		line = line[daemonLogMaxLine:]
+	}
+	return append(lines, line)
+}
+
+// flush 写入进程退出时未以换行结尾的内容
+func (w *daemonLogWriter) flush() {
+	if len(w.buf) > 0 {
+		w.job.writeLines(w.stream, [][]byte{w.buf})
+		w.buf = nil
+	}
+}
+
+// daemonLogStream 解析日志行的输出流标记，旧格式的日志返回空
+func daemonLogStream(line []byte) string {
+	n := len(proto.TimeMicroLayout)
+	if len(line) < n+3 || line[n] != ' ' || line[n+1] != '[' {
+		return ""
+	}
+	end := bytes.IndexByte(line[n+2:], ']')
+	if end < 0 {
+		return ""
+	}
+	return string(line[n+2 : n+2+end])
+}
+
+// daemonLogSegment 同一天的日志按轮转序号分段，序号0为正在写入的文件
+type daemonLogSegment struct {
+	path string
+	seq  int
+	gzip bool
+}
+
+// size 返回分段解压后的大小，gzip文件读取尾部记录的原始长度
+func (s *daemonLogSegment) size() int64 {
+	if !s.gzip {
+		fi, err := os.Stat(s.path)
+		if err != nil {
+			return 0
+		}
+		return fi.Size()
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	b := make([]byte, 4)
+	fi, err := f.Stat()
+	if err != nil || fi.Size() < 4 {
+		return 0
+	}
+	if _, err = f.ReadAt(b, fi.Size()-4); err != nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint32(b))
+}
+
+// daemonLogSegments 按写入顺序返回日志分段，压缩中的分段优先读取未压缩的文件
+func daemonLogSegments(logPath string) []*daemonLogSegment {
+	matches, _ := filepath.Glob(logPath + ".*")
+	seen := make(map[int]*daemonLogSegment)
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, logPath+".")
+		gz := strings.HasSuffix(suffix, ".gz")
+		seq, err := strconv.Atoi(strings.TrimSuffix(suffix, ".gz"))
+		if err != nil || seq <= 0 {
+			continue
+		}
+		if s, ok := seen[seq]; ok && !s.gzip {
+			continue
+		}
+		seen[seq] = &daemonLogSegment{path: m, seq: seq, gzip: gz}
+	}
+	segments := make([]*daemonLogSegment, 0, len(seen)+1)
+	for _, s := range seen {
+		segments = append(segments, s)
+	}
+	sort.Slice(segments, func(i, k int) bool {
+		return segments[i].seq < segments[k].seq
+	})
+	if _, err := os.Stat(logPath); err == nil {
+		segments = append(segments, &daemonLogSegment{path: logPath})
+	}
+	return segments
+}
+
+type daemonLogReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *daemonLogReader) Close() error {
+	for _, c := range r.closers {
+		_ = c.Close()
+	}
+	return nil
+}
+
+// openDaemonLog 将所有分段拼接为一个连续的读取流，offset为拼接后的偏移量
+func openDaemonLog(segments []*daemonLogSegment, offset int64) (io.ReadCloser, error) {
+	r := &daemonLogReader{}
+	readers := make([]io.Reader, 0, len(segments))
+	for _, s := range segments {
+		if offset > 0 {
+			size := s.size()
+			if offset >= size {
+				offset -= size
+				continue
+			}
+		}
+		f, err := os.Open(s.path)
+		if err != nil {
+			_ = r.Close()
+			return nil, errors.New("无权限访问日志文件")
+		}
+		r.closers = append(r.closers, f)
+		var reader io.Reader = f
+		if s.gzip {
+			gr, err := gzip.NewReader(f)
+			if err != nil {
+				_ = r.Close()
+				return nil, errors.New("日志文件已损坏")
+			}
+			r.closers = append(r.closers, gr)
+			reader = gr
+			if offset > 0 {
+				_, _ = io.CopyN(io.Discard, gr, offset)
+			}
+		} else if offset > 0 {
+			_, _ = f.Seek(offset, io.SeekStart)
+		}
+		offset = 0
+		readers = append(readers, reader)
+	}
+	r.Reader = io.MultiReader(readers...)
+	return r, nil
+}
+
+// logPolicy 任务自身的配置优先于节点默认值，单位分别为MB、天、MB
+func logPolicy(v *model.Daemon) (maxSize uint, maxAge uint, maxTotal uint) {
+	maxSize, maxAge, maxTotal = v.LogMaxSize, v.LogMaxAge, v.LogMaxTotal
+	if maxSize == 0 {
+		maxSize = config.DaemonLogMaxSize()
+	}
+	if maxAge == 0 {
+		maxAge = config.DaemonLogMaxAge()
+	}
+	if maxTotal == 0 {
+		maxTotal = config.DaemonLogMaxTotal()
+	}
+	return
+}
+
+// rotate 将当前日志重命名为下一个序号的分段并重新打开
+func (j *daemonJob) rotate() {
+	_ = j.logFile.Close()
+	seq := 1
+	for _, s := range daemonLogSegments(j.logPath) {
+		if s.seq >= seq {
+			seq = s.seq + 1
+		}
+	}
+	rotated := fmt.Sprintf("%s.%d", j.logPath, seq)
+	renameErr := os.Rename(j.logPath, rotated)
+	err := j.openLogFile(j.logPath)
+	if err != nil {
+		Zap.Sugar().Errorf("%s write log failed, %s \n", j.name(), err.Error())
+	}
+	if renameErr != nil {
+		// 重命名失败时继续写入原文件，避免每次写入都尝试轮转
+		j.logSize = 0
+		Zap.Sugar().Errorf("%s rotate log failed, %s \n", j.name(), renameErr.Error())
+		return
+	}
+	if config.DaemonLogCompress() {
+		go compressLog(rotated)
+	}
+}
+
+func compressLog(path string) {
+	tmp := path + ".gz.tmp"
+	err := func() error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = src.Close()
+		}()
+		dst, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = dst.Close()
+		}()
+		gw := gzip.NewWriter(dst)
+		if _, err = io.Copy(gw, src); err != nil {
+			return err
+		}
+		return gw.Close()
+	}()
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		Zap.Sugar().Errorf("compress log %s failed, %s \n", path, err.Error())
+		return
+	}
+	_ = os.Remove(path)
+}
+
+type daemonLogFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (d *daemon) purgeLog() {
+	ticker := time.NewTicker(time.Duration(config.DaemonLogPurgeInterval()) * time.Second)
+	for {
+		d.purge()
+		<-ticker.C
+	}
+}
+
+// purge 按保留天数和总大小清理日志，已删除任务的日志使用节点默认值，当天正在写入的文件不清理
+func (d *daemon) purge() {
+	var ds []*model.Daemon
+	err := model.Task().Select("id", "log_max_age", "log_max_total").Find(&ds).Error
+	if err != nil {
+		Zap.Sugar().Errorf("[Daemon]purge log failed, %s", err.Error())
+		return
+	}
+	daemons := make(map[uint]*model.Daemon, len(ds))
+	for _, v := range ds {
+		daemons[v.ID] = v
+	}
+	files := make(map[uint][]*daemonLogFile)
+	_ = filepath.Walk(config.DaemonLogRoot(), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		ID, ok := daemonLogID(info.Name())
+		if !ok {
+			return nil
+		}
+		files[ID] = append(files[ID], &daemonLogFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	today := time.Now().Format(proto.LogPathTimeLayout)
+	for ID, fs := range files {
+		v, ok := daemons[ID]
+		if !ok {
+			v = &model.Daemon{ID: ID}
+		}
+		_, maxAge, maxTotal := logPolicy(v)
+		sort.Slice(fs, func(i, k int) bool {
+			return fs[i].modTime.Before(fs[k].modTime)
+		})
+		var total int64
+		for _, f := range fs {
+			total += f.size
+		}
+		expireTime := time.Now().AddDate(0, 0, -int(maxAge))
+		for _, f := range fs {
+			if filepath.Base(filepath.Dir(f.path)) == today && (strings.HasSuffix(f.path, ".log") || strings.HasSuffix(f.path, ".tmp")) {
+				continue
+			}
+			expired := maxAge > 0 && f.modTime.Before(expireTime)
+			oversize := maxTotal > 0 && total > int64(maxTotal)*1024*1024
+			if !expired && !oversize {
+				continue
+			}
+			if err = os.Remove(f.path); err == nil {
+				total -= f.size
+			}
+		}
+	}
+	// 生命周期记录与日志文件按相同的保留天数清理
+	for _, v := range ds {
+		_, maxAge, _ := logPolicy(v)
+		if maxAge > 0 {
+			model.Task().Where("daemon_id=? and create_time<?", v.ID, time.Now().AddDate(0, 0, -int(maxAge)).Unix()).Delete(&model.DaemonLog{})
+		}
+	}
+	dirs, _ := os.ReadDir(config.DaemonLogRoot())
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == today {
+			continue
+		}
+		p := filepath.Join(config.DaemonLogRoot(), dir.Name())
+		if entries, err := os.ReadDir(p); err == nil && len(entries) == 0 {
+			_ = os.Remove(p)
+		}
+	}
+}
+
+// daemonLogID 从 <id>.log、<id>_<instance>.log 及其分段文件名中解析任务ID
+func daemonLogID(name string) (uint, bool) {
+	i := strings.IndexAny(name, "._")
+	if i <= 0 {
+		return 0, false
+	}
+	ID, err := strconv.Atoi(name[:i])
+	if err != nil {
+		return 0, false
+	}
+	return uint(ID), true
+}
+
+const (
+	daemonLogSearchMaxDays    = 31
+	daemonLogSearchMaxLimit   = 1000
+	daemonLogSearchMaxContext = 20
+	daemonLogChunkSize        = 1024 * 1024
+)
+
+// searchDaemonLog 按日期范围查找日志，Reverse时从最后一天的末尾往前返回最近的匹配
+func searchDaemonLog(request *proto.DaemonLogSearchArgs, response *proto.DaemonLogSearchReply) error {
+	if request.Keyword == "" {
+		return errors.New("关键字不能为空")
+	}
+	reg, err := regexp.Compile(request.Keyword)
+	if err != nil {
+		return errors.New("关键字不合法")
+	}
+	dates, err := daemonLogDates(request.StartDate, request.EndDate)
+	if err != nil {
+		return err
+	}
+	if request.Reverse {
+		for i, k := 0, len(dates)-1; i < k; i, k = i+1, k-1 {
+			dates[i], dates[k] = dates[k], dates[i]
+		}
+	}
+	limit := int(request.Limit)
+	if limit <= 0 || limit > daemonLogSearchMaxLimit {
+		limit = 100
+	}
+	contextLines := int(request.Context)
+	if contextLines > daemonLogSearchMaxContext {
+		contextLines = daemonLogSearchMaxContext
+	}
+	response.Matches = make([]*proto.DaemonLogMatch, 0)
+	for _, date := range dates {
+		remain := limit - len(response.Matches)
+		if remain <= 0 {
+			response.Truncated = true
+			break
+		}
+		segments := daemonLogSegments(config.DaemonInstanceLogPath(request.DaemonID, request.Instance, date))
+		if len(segments) == 0 {
+			continue
+		}
+		search := searchDaemonLogFile
+		if request.Reverse {
+			search = searchDaemonLogFileReverse
+		}
+		matches, truncated, err := search(segments, date, reg, request.Stream, contextLines, remain)
+		if err != nil {
+			return err
+		}
+		response.Matches = append(response.Matches, matches...)
+		if truncated {
+			response.Truncated = true
+			break
+		}
+	}
+	return nil
+}
+
+func daemonLogDates(startDate string, endDate string) ([]string, error) {
+	if startDate == "" {
+		startDate = time.Now().Format(proto.LogPathTimeLayout)
+	}
+	if endDate == "" {
+		endDate = startDate
+	}
+	start, err := time.Parse(proto.LogPathTimeLayout, startDate)
+	if err != nil {
+		return nil, errors.New("开始日期不合法")
+	}
+	end, err := time.Parse(proto.LogPathTimeLayout, endDate)
+	if err != nil {
+		return nil, errors.New("结束日期不合法")
+	}
+	if end.Before(start) {
+		return nil, errors.New("结束日期不能早于开始日期")
+	}
+	if end.Sub(start) >= daemonLogSearchMaxDays*24*time.Hour {
+		return nil, fmt.Errorf("日期范围不能超过%d天", daemonLogSearchMaxDays)
+	}
+	var dates []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format(proto.LogPathTimeLayout))
+	}
+	return dates, nil
+}
+
+// searchDaemonLogFile 顺序读取一天的全部分段，达到limit时返回truncated
+func searchDaemonLogFile(segments []*daemonLogSegment, date string, reg *regexp.Regexp, stream string, contextLines int, limit int) ([]*proto.DaemonLogMatch, bool, error) {
+	f, err := openDaemonLog(segments, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	reader := bufio.NewReader(f)
+	var (
+		matches []*proto.DaemonLogMatch
+		pending []*proto.DaemonLogMatch
+		before  []string
+		lineNum uint
+		offset  int64
+	)
+	for {
+		line, _ := reader.ReadBytes('\n')
+		if len(line) == 0 {
+			break
+		}
+		lineNum++
+		lineOffset := offset
+		offset += int64(len(line))
+		if stream != "" && daemonLogStream(line) != stream {
+			continue
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		for _, m := range pending {
+			m.After = append(m.After, text)
+		}
+		if len(pending) > 0 && len(pending[0].After) >= contextLines {
+			pending = pending[1:]
+		}
+		if len(matches) >= limit {
+			// 已达到数量，只需补全最后一条匹配的后续行
+			if len(pending) == 0 {
+				break
+			}
+			continue
+		}
+		if reg.Match(line) {
+			m := &proto.DaemonLogMatch{
+				Date:    date,
+				Line:    lineNum,
+				Offset:  lineOffset,
+				Before:  append([]string(nil), before...),
+				Content: text,
+				After:   make([]string, 0, contextLines),
+			}
+			matches = append(matches, m)
+			if contextLines > 0 {
+				pending = append(pending, m)
+			}
+		}
+		if contextLines > 0 {
+			before = append(before, text)
+			if len(before) > contextLines {
+				before = before[1:]
+			}
+		}
+	}
+	return matches, len(matches) >= limit, nil
+}
+
+// searchDaemonLogFileReverse 从最后一个分段的末尾按块往前读取，找到limit条匹配并补全其前面的上下文后停止
+func searchDaemonLogFileReverse(segments []*daemonLogSegment, date string, reg *regexp.Regexp, stream string, contextLines int, limit int) ([]*proto.DaemonLogMatch, bool, error) {
+	r := newDaemonLogReverseReader(segments)
+	defer r.close()
+	var (
+		matches []*proto.DaemonLogMatch
+		pending []*proto.DaemonLogMatch
+		after   []string
+		// readLines 已往前读取的行数，readOffset 最前面一行的偏移
+		readLines  uint
+		readOffset int64
+	)
+	for {
+		line, offset, err := r.prev()
+		if err != nil {
+			return nil, false, err
+		}
+		if line == nil {
+			break
+		}
+		readLines++
+		readOffset = offset
+		if stream != "" && daemonLogStream(line) != stream {
+			continue
+		}
+		text := strings.TrimSuffix(string(line), "\n")
+		// 往前读取时先读到的是匹配行之后的内容，后读到的是之前的内容
+		for _, m := range pending {
+			m.Before = append([]string{text}, m.Before...)
+		}
+		if len(pending) > 0 && len(pending[0].Before) >= contextLines {
+			pending = pending[1:]
+		}
+		if len(matches) >= limit {
+			if len(pending) == 0 {
+				break
+			}
+			continue
+		}
+		if reg.Match(line) {
+			// 先记录是倒数第几行，读取结束后再换算为行号
+			m := &proto.DaemonLogMatch{
+				Date:    date,
+				Line:    readLines,
+				Offset:  offset,
+				Before:  make([]string, 0, contextLines),
+				Content: text,
+				After:   append([]string(nil), after...),
+			}
+			matches = append(matches, m)
+			if contextLines > 0 {
+				pending = append(pending, m)
+			}
+		}
+		if contextLines > 0 {
+			after = append([]string{text}, after...)
+			if len(after) > contextLines {
+				after = after[:contextLines]
+			}
+		}
+	}
+	if len(matches) > 0 {
+		// 未读取的开头部分只统计换行数，不逐行解析
+		total, err := countDaemonLogLines(segments, readOffset)
+		if err != nil {
+			return nil, false, err
+		}
+		total += readLines
+		for _, m := range matches {
+			m.Line = total - m.Line + 1
+		}
+	}
+	return matches, len(matches) >= limit, nil
+}
+
+// countDaemonLogLines 统计当天日志[0, end)内的行数，end需位于行首
+func countDaemonLogLines(segments []*daemonLogSegment, end int64) (uint, error) {
+	if end <= 0 {
+		return 0, nil
+	}
+	f, err := openDaemonLog(segments, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var n uint
+	buf := make([]byte, daemonLogReverseBlock)
+	reader := io.LimitReader(f, end)
+	for {
+		k, err := reader.Read(buf)
+		n += uint(bytes.Count(buf[:k], []byte{'\n'}))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// daemonLogReverseBlock 倒序查找每次往前读取的大小
+const daemonLogReverseBlock = 64 * 1024
+
+// daemonLogReverseReader 从后往前逐行读取一天的全部分段，未压缩的分段按块读取，压缩的分段只能整段解压后读取
+type daemonLogReverseReader struct {
+	segments []*daemonLogSegment
+	bases    []int64
+	index    int
+	file     io.ReaderAt
+	closer   io.Closer
+	pos      int64
+	// buf 尚未返回的内容，位于当天日志的[start, start+len(buf))
+	buf   []byte
+	start int64
+}
+
+func newDaemonLogReverseReader(segments []*daemonLogSegment) *daemonLogReverseReader {
+	r := &daemonLogReverseReader{segments: segments, bases: make([]int64, len(segments)), index: len(segments)}
+	var base int64
+	for i, s := range segments {
+		r.bases[i] = base
+		base += s.size()
+	}
+	r.start = base
+	return r
+}
+
+// prev 返回前一行及其偏移，读取到开头时返回nil
+func (r *daemonLogReverseReader) prev() ([]byte, int64, error) {
+	for {
+		if len(r.buf) > 0 {
+			// 末尾的换行属于当前行，从它之前查找上一行的换行
+			if i := bytes.LastIndexByte(r.buf[:len(r.buf)-1], '\n'); i >= 0 {
+				line := r.buf[i+1:]
+				r.buf = r.buf[:i+1]
+				return line, r.start + int64(i) + 1, nil
+			}
+		}
+		more, err := r.fill()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !more {
+			if len(r.buf) == 0 {
+				return nil, 0, nil
+			}
+			line := r.buf
+			r.buf = nil
+			return line, r.start, nil
+		}
+	}
+}
+
+// fill 往前读取一块拼接到buf之前，所有分段都读完时返回false
+func (r *daemonLogReverseReader) fill() (bool, error) {
+	for r.file == nil || r.pos == 0 {
+		if r.index == 0 {
+			return false, nil
+		}
+		r.index--
+		if err := r.open(r.segments[r.index]); err != nil {
+			return false, err
+		}
+	}
+	n := int64(daemonLogReverseBlock)
+	if n > r.pos {
+		n = r.pos
+	}
+	block := make([]byte, n, n+int64(len(r.buf)))
+	if _, err := r.file.ReadAt(block, r.pos-n); err != nil && err != io.EOF {
+		return false, err
+	}
+	r.pos -= n
+	r.buf = append(block, r.buf...)
+	r.start = r.bases[r.index] + r.pos
+	return true, nil
+}
+
+func (r *daemonLogReverseReader) open(s *daemonLogSegment) error {
+	r.close()
+	f, err := os.Open(s.path)
+	if err != nil {
+		return errors.New("无权限访问日志文件")
+	}
+	if !s.gzip {
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		r.file, r.closer, r.pos = f, f, fi.Size()
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.New("日志文件已损坏")
+	}
+	data, err := io.ReadAll(gr)
+	if err != nil {
+		return errors.New("日志文件已损坏")
+	}
+	r.file, r.pos = bytes.NewReader(data), int64(len(data))
+	return nil
+}
+
+func (r *daemonLogReverseReader) close() {
+	if r.closer != nil {
+		_ = r.closer.Close()
+		r.closer = nil
+	}
+	r.file = nil
+}
+
+// readDaemonLogChunk 按偏移量读取拼接后的日志，Size为当前的总大小
+func readDaemonLogChunk(request *proto.DaemonLogArgs, response *proto.DaemonLogChunk) error {
+	segments := daemonLogSegments(config.DaemonInstanceLogPath(request.DaemonID, request.Instance, request.Date))
+	if len(segments) == 0 {
+		return errors.New("日志文件不存在")
+	}
+	for _, s := range segments {
+		response.Size += s.size()
+	}
+	f, err := openDaemonLog(segments, int64(request.Offset))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	response.Data = make([]byte, daemonLogChunkSize)
+	n, err := io.ReadFull(f, response.Data)
+	response.Data = response.Data[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	response.EOF = int64(request.Offset)+int64(n) >= response.Size
+	return nil
+}
@@ -0,0 +1,435 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestDaemonLogSegments(t *testing.T) {
+	cases := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"live only", []string{"1.log"}, []string{"1.log"}},
+		{"rotated in order", []string{"1.log", "1.log.2", "1.log.10", "1.log.1"}, []string{"1.log.1", "1.log.2", "1.log.10", "1.log"}},
+		{"compressed", []string{"1.log", "1.log.1.gz", "1.log.2"}, []string{"1.log.1.gz", "1.log.2", "1.log"}},
+		{"prefer uncompressed while compressing", []string{"1.log.1", "1.log.1.gz"}, []string{"1.log.1"}},
+		{"ignore other files", []string{"1.log", "1.log.0", "1.log.x", "1.log.1.gz.tmp", "1_1.log.1", "11.log.1"}, []string{"1.log"}},
+		{"no live file", []string{"1.log.1"}, []string{"1.log.1"}},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		for _, f := range c.files {
+			writeFile(t, filepath.Join(dir, f), "")
+		}
+		var got []string
+		for _, s := range daemonLogSegments(filepath.Join(dir, "1.log")) {
+			if s.gzip != strings.HasSuffix(s.path, ".gz") {
+				t.Fatalf("%s: %s gzip flag mismatch", c.name, s.path)
+			}
+			got = append(got, filepath.Base(s.path))
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "1.log")
+	j := &daemonJob{value: &model.Daemon{ID: 1, Name: "rotate"}}
+	if err := j.openLogFile(logPath); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = j.logFile.Close()
+	}()
+	for _, content := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := j.logFile.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+		j.rotate()
+	}
+	_, _ = j.logFile.WriteString("live\n")
+	if j.logSize != 0 {
+		t.Fatalf("log size should be reset after rotation, got %d", j.logSize)
+	}
+	compressLog(logPath + ".2")
+	if _, err := os.Stat(logPath + ".2"); !os.IsNotExist(err) {
+		t.Fatal("compressed segment should be removed")
+	}
+	segments := daemonLogSegments(logPath)
+	var names []string
+	for _, s := range segments {
+		names = append(names, filepath.Base(s.path))
+	}
+	if want := "1.log.1,1.log.2.gz,1.log.3,1.log"; strings.Join(names, ",") != want {
+		t.Fatalf("got segments %v, want %s", names, want)
+	}
+	if size := segments[1].size(); size != int64(len("second\n")) {
+		t.Fatalf("gzip segment size %d, want %d", size, len("second\n"))
+	}
+	cases := []struct {
+		offset int64
+		want   string
+	}{
+		{0, "first\nsecond\nthird\nlive\n"},
+		{3, "st\nsecond\nthird\nlive\n"},
+		{6, "second\nthird\nlive\n"},
+		{9, "ond\nthird\nlive\n"},
+		{19, "live\n"},
+		{20, "ive\n"},
+	}
+	for _, c := range cases {
+		r, err := openDaemonLog(segments, c.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.want {
+			t.Fatalf("offset %d: got %q, want %q", c.offset, b, c.want)
+		}
+	}
+}
+
+func TestDaemonLogID(t *testing.T) {
+	cases := []struct {
+		name string
+		ID   uint
+		ok   bool
+	}{
+		{"12.log", 12, true},
+		{"12_3.log", 12, true},
+		{"12.log.4", 12, true},
+		{"12_3.log.4.gz", 12, true},
+		{"12.log.4.gz.tmp", 12, true},
+		{"x.log", 0, false},
+		{".log", 0, false},
+		{"12", 0, false},
+	}
+	for _, c := range cases {
+		ID, ok := daemonLogID(c.name)
+		if ID != c.ID || ok != c.ok {
+			t.Fatalf("%s: got %d %v, want %d %v", c.name, ID, ok, c.ID, c.ok)
+		}
+	}
+}
+
+// daemonLogLines 读取日志并去掉每行的时间前缀
+func daemonLogLines(t *testing.T, logPath string) []string {
+	t.Helper()
+	b, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+		if daemonLogStream([]byte(line)) == "" {
+			t.Fatalf("line without stream tag: %q", line)
+		}
+		lines = append(lines, line[len(proto.TimeMicroLayout)+1:])
+	}
+	return lines
+}
+
+// removeDaemonLog 删除当天的日志，避免重复执行测试时读到上次写入的内容
+func removeDaemonLog(ID uint) {
+	_ = os.Remove(config.DaemonLogPath(ID, time.Now().Format(proto.LogPathTimeLayout)))
+}
+
+func TestDaemonLogWriter(t *testing.T) {
+	removeDaemonLog(91)
+	j := &daemonJob{value: &model.Daemon{ID: 91, Name: "writer"}, secrets: []string{"s3cret"}}
+	if err := j.setLogFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = j.logFile.Close()
+	}()
+	stdout := &daemonLogWriter{job: j, stream: proto.LogStreamStdout}
+	stderr := &daemonLogWriter{job: j, stream: proto.LogStreamStderr}
+	long := strings.Repeat("x", daemonLogMaxLine+10)
+	for _, w := range []struct {
+		w *daemonLogWriter
+		s string
+	}{
+		{stdout, "a\nb"},
+		{stdout, "c s3cret\n"},
+		{stdout, long},
+		{stderr, "err\n"},
+		{stdout, "\n" + long + "\n"},
+		{stderr, "tail"},
+	} {
+		if n, err := w.w.Write([]byte(w.s)); n != len(w.s) || err != nil {
+			t.Fatalf("write %d bytes: got %d %v", len(w.s), n, err)
+		}
+	}
+	stdout.flush()
+	stderr.flush()
+	j.writeLog(proto.LogStreamHook, []byte("hook line 1\nhook line 2\n"))
+	j.writeLog(proto.LogStreamHook, nil)
+	want := []string{
+		"[stdout] a",
+		"[stdout] bc " + proto.SecretMask,
+		"[stdout] " + long[:daemonLogMaxLine],
+		"[stderr] err",
+		"[stdout] " + long[daemonLogMaxLine:],
+		"[stdout] " + long[:daemonLogMaxLine],
+		"[stdout] " + long[daemonLogMaxLine:],
+		"[stderr] tail",
+		"[hook] hook line 1",
+		"[hook] hook line 2",
+	}
+	got := daemonLogLines(t, j.logPath)
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("line %d: got %.40q, want %.40q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDaemonLogCapture(t *testing.T) {
+	removeDaemonLog(92)
+	j := &daemonJob{value: &model.Daemon{ID: 92, Name: "capture"}}
+	if err := j.setLogFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = j.logFile.Close()
+	}()
+	stdout := &daemonLogWriter{job: j, stream: proto.LogStreamStdout}
+	stderr := &daemonLogWriter{job: j, stream: proto.LogStreamStderr}
+	cmd := exec.Command("sh", "-c", "for i in 1 2 3; do echo out$i; echo err$i >&2; done; printf tail")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	stdout.flush()
+	stderr.flush()
+	var out, errs []string
+	for _, line := range daemonLogLines(t, j.logPath) {
+		if strings.HasPrefix(line, "[stdout] ") {
+			out = append(out, strings.TrimPrefix(line, "[stdout] "))
+		} else {
+			errs = append(errs, strings.TrimPrefix(line, "[stderr] "))
+		}
+	}
+	if strings.Join(out, ",") != "out1,out2,out3,tail" || strings.Join(errs, ",") != "err1,err2,err3" {
+		t.Fatalf("got stdout %v stderr %v", out, errs)
+	}
+
+	ds := &DaemonServe{}
+	date := filepath.Base(filepath.Dir(j.logPath))
+	for _, c := range []struct {
+		stream  string
+		keyword string
+		want    int
+	}{
+		{"", "", 7},
+		{proto.LogStreamStdout, "", 4},
+		{proto.LogStreamStderr, "", 3},
+		{proto.LogStreamStderr, "err[12]", 2},
+		{proto.LogStreamHook, "", 0},
+	} {
+		var reply proto.DaemonLogReply
+		err := ds.Log(proto.DaemonLogArgs{DaemonID: 92, Date: date, Stream: c.stream, Keyword: c.keyword, Size: 100}, &reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply.Content) != c.want {
+			t.Fatalf("stream %q keyword %q: got %d lines, want %d", c.stream, c.keyword, len(reply.Content), c.want)
+		}
+		for _, line := range reply.Content {
+			if c.stream != "" && daemonLogStream([]byte(line)) != c.stream {
+				t.Fatalf("stream %q: got line %q", c.stream, line)
+			}
+		}
+	}
+}
+
+func searchLogLine(i int) string {
+	stream := "stdout"
+	if i%3 == 0 {
+		stream = "stderr"
+	}
+	return fmt.Sprintf("2026-10-19 10:00:00.000 [%s] line %d", stream, i)
+}
+
+// writeSearchLog 写入1到n行日志，平均分为已压缩、已轮转和正在写入的三个分段，返回每行的偏移
+func writeSearchLog(t *testing.T, n int) ([]*daemonLogSegment, []int64) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "1.log")
+	paths := []string{logPath + ".1", logPath + ".2", logPath}
+	offsets := make([]int64, n+1)
+	var offset int64
+	for k, path := range paths {
+		var b strings.Builder
+		for i := k*n/3 + 1; i <= (k+1)*n/3; i++ {
+			offsets[i] = offset
+			line := searchLogLine(i) + "\n"
+			offset += int64(len(line))
+			b.WriteString(line)
+		}
+		writeFile(t, path, b.String())
+	}
+	compressLog(paths[0])
+	return daemonLogSegments(logPath), offsets
+}
+
+func TestSearchDaemonLogFile(t *testing.T) {
+	segments, offsets := writeSearchLog(t, 30)
+	type match struct {
+		line   int
+		before []int
+		after  []int
+	}
+	cases := []struct {
+		name        string
+		keyword     string
+		stream      string
+		context     int
+		limit       int
+		want        []match
+		wantReverse []match
+		truncated   bool
+	}{
+		{"all segments", `line \d*5\b`, "", 0, 10, []match{{line: 5}, {line: 15}, {line: 25}}, []match{{line: 25}, {line: 15}, {line: 5}}, false},
+		{"limit", `line \d*5\b`, "", 0, 2, []match{{line: 5}, {line: 15}}, []match{{line: 25}, {line: 15}}, true},
+		{"stream", `line \d*5\b`, "stderr", 0, 10, []match{{line: 15}}, []match{{line: 15}}, false},
+		{"context across segments", `line (10|11)\b`, "", 1, 10,
+			[]match{{10, []int{9}, []int{11}}, {11, []int{10}, []int{12}}},
+			[]match{{11, []int{10}, []int{12}}, {10, []int{9}, []int{11}}},
+			false,
+		},
+		{"context within stream", `line 4\b`, "stdout", 1, 10, []match{{4, []int{2}, []int{5}}}, []match{{4, []int{2}, []int{5}}}, false},
+		{"context at edges", `line (1|30)\b`, "", 2, 10,
+			[]match{{1, nil, []int{2, 3}}, {30, []int{28, 29}, nil}},
+			[]match{{30, []int{28, 29}, nil}, {1, nil, []int{2, 3}}},
+			false,
+		},
+		{"no match", `nothing`, "", 2, 10, nil, nil, false},
+	}
+	lines := func(ns []int) string {
+		texts := make([]string, 0, len(ns))
+		for _, i := range ns {
+			texts = append(texts, searchLogLine(i))
+		}
+		return strings.Join(texts, "|")
+	}
+	check := func(name string, reverse bool, got []*proto.DaemonLogMatch, want []match) {
+		if len(got) != len(want) {
+			t.Fatalf("%s reverse=%v: got %d matches, want %d", name, reverse, len(got), len(want))
+		}
+		for k, w := range want {
+			m := got[k]
+			if m.Content != searchLogLine(w.line) || m.Offset != offsets[w.line] || m.Date != "2026-10-19" {
+				t.Fatalf("%s reverse=%v: match %d is %q at %d, want line %d", name, reverse, k, m.Content, m.Offset, w.line)
+			}
+			if m.Line != uint(w.line) {
+				t.Fatalf("%s reverse=%v: match %d has line number %d", name, reverse, k, m.Line)
+			}
+			if strings.Join(m.Before, "|") != lines(w.before) || strings.Join(m.After, "|") != lines(w.after) {
+				t.Fatalf("%s reverse=%v: match %d context %v %v", name, reverse, k, m.Before, m.After)
+			}
+		}
+	}
+	for _, c := range cases {
+		reg := regexp.MustCompile(c.keyword)
+		got, truncated, err := searchDaemonLogFile(segments, "2026-10-19", reg, c.stream, c.context, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(c.name, false, got, c.want)
+		if truncated != c.truncated {
+			t.Fatalf("%s: truncated %v, want %v", c.name, truncated, c.truncated)
+		}
+		got, truncated, err = searchDaemonLogFileReverse(segments, "2026-10-19", reg, c.stream, c.context, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(c.name, true, got, c.wantReverse)
+		if truncated != c.truncated {
+			t.Fatalf("%s reverse: truncated %v, want %v", c.name, truncated, c.truncated)
+		}
+	}
+}
+
+// TestSearchDaemonLogFileReverseBlocks 日志跨越多个读取块时，倒序查找与顺序查找的结果一致
+func TestSearchDaemonLogFileReverseBlocks(t *testing.T) {
+	segments, _ := writeSearchLog(t, 15000)
+	reg := regexp.MustCompile(`line \d*77\b`)
+	forward, _, err := searchDaemonLogFile(segments, "2026-10-19", reg, "", 3, daemonLogSearchMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverse, _, err := searchDaemonLogFileReverse(segments, "2026-10-19", reg, "", 3, daemonLogSearchMaxLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forward) != 150 || len(reverse) != len(forward) {
+		t.Fatalf("got %d forward and %d reverse matches, want 150", len(forward), len(reverse))
+	}
+	for k, f := range forward {
+		r := reverse[len(reverse)-1-k]
+		if r.Content != f.Content || r.Line != f.Line || r.Offset != f.Offset || strings.Join(r.Before, "|") != strings.Join(f.Before, "|") || strings.Join(r.After, "|") != strings.Join(f.After, "|") {
+			t.Fatalf("match %d differs, forward %+v, reverse %+v", k, f, r)
+		}
+	}
+	// 提前结束时未读取的开头部分只统计行数
+	reverse, _, err = searchDaemonLogFileReverse(segments, "2026-10-19", reg, "", 3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverse) != 5 {
+		t.Fatalf("got %d limited matches, want 5", len(reverse))
+	}
+	for k, r := range reverse {
+		if f := forward[len(forward)-1-k]; r.Line != f.Line || r.Offset != f.Offset {
+			t.Fatalf("limited match %d at line %d offset %d, want line %d offset %d", k, r.Line, r.Offset, f.Line, f.Offset)
+		}
+	}
+}
+
+func TestDaemonLogDates(t *testing.T) {
+	cases := []struct {
+		name    string
+		start   string
+		end     string
+		want    []string
+		wantErr bool
+	}{
+		{"single day", "2026-10-19", "", []string{"2026-10-19"}, false},
+		{"across months", "2026-09-29", "2026-10-02", []string{"2026-09-29", "2026-09-30", "2026-10-01", "2026-10-02"}, false},
+		{"end before start", "2026-10-19", "2026-10-18", nil, true},
+		{"too many days", "2026-09-01", "2026-10-19", nil, true},
+		{"invalid date", "2026/10/19", "", nil, true},
+	}
+	for _, c := range cases {
+		got, err := daemonLogDates(c.start, c.end)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: got error %v", c.name, err)
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
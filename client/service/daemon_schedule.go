@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"task/model"
+	pkgcrontab "task/pkg/crontab"
+	"time"
+)
+
+// daemonWindow 常驻任务自动开启和停止的下次时间，表达式修改后重新计算
+type daemonWindow struct {
+	startCron string
+	stopCron  string
+	nextStart time.Time
+	nextStop  time.Time
+}
+
+// checkSchedule 校验自动开启和停止的时间表达式
+func checkSchedule(v *model.Daemon) error {
+	now := time.Now()
+	if v.StartCron != "" {
+		if _, err := parseCron(v.StartCron, now); err != nil {
+			return errors.New("自动开启的时间表达式不合法")
+		}
+	}
+	if v.StopCron != "" {
+		if _, err := parseCron(v.StopCron, now); err != nil {
+			return errors.New("自动停止的时间表达式不合法")
+		}
+	}
+	return nil
+}
+
+// parseCron 部分不合法的表达式会使解析panic，这里转为错误返回
+func parseCron(expr string, t time.Time) (nt time.Time, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errors.New("invalid expr")
+		}
+	}()
+	return pkgcrontab.NewParse(expr).NextExecTime(t)
+}
+
+// nextCronTime 未配置或解析失败时返回零值
+func nextCronTime(expr string, t time.Time) time.Time {
+	if expr == "" {
+		return time.Time{}
+	}
+	nt, err := parseCron(expr, t)
+	if err != nil {
+		return time.Time{}
+	}
+	return nt
+}
+
+// schedule 每秒检查到期的计划，按计划开启或停止任务
+func (d *daemon) schedule() {
+	windows := make(map[uint]*daemonWindow)
+	ticker := time.NewTicker(time.Second)
+	for {
+		<-ticker.C
+		var ds []*model.Daemon
+		err := model.Task().Where("start_cron<>'' or stop_cron<>''").Find(&ds).Error
+		if err != nil {
+			Zap.Sugar().Errorf("[Daemon]load schedule failed, %s", err.Error())
+			continue
+		}
+		now := time.Now()
+		seen := make(map[uint]bool, len(ds))
+		for _, v := range ds {
+			seen[v.ID] = true
+			w, ok := windows[v.ID]
+			if !ok || w.startCron != v.StartCron || w.stopCron != v.StopCron {
+				windows[v.ID] = &daemonWindow{
+					startCron: v.StartCron,
+					stopCron:  v.StopCron,
+					nextStart: nextCronTime(v.StartCron, now),
+					nextStop:  nextCronTime(v.StopCron, now),
+				}
+				continue
+			}
+			if !w.nextStart.IsZero() && !now.Before(w.nextStart) {
+				w.nextStart = nextCronTime(v.StartCron, now)
+				d.scheduledStart(v)
+			}
+			if !w.nextStop.IsZero() && !now.Before(w.nextStop) {
+				w.nextStop = nextCronTime(v.StopCron, now)
+				d.scheduledStop(v)
+			}
+		}
+		for ID := range windows {
+			if !seen[ID] {
+				delete(windows, ID)
+			}
+		}
+	}
+}
+
+// scheduledStart 只开启已审核且未运行的任务
+func (d *daemon) scheduledStart(v *model.Daemon) {
+	if v.Status != model.StatusOk && v.Status != model.StatusStopped {
+		return
+	}
+	Zap.Sugar().Infof("[Daemon]scheduled start %s", v.Name)
+	err := d.startDaemon(v, nil, model.DaemonTriggerSchedule, 0)
+	if err != nil {
+		Zap.Sugar().Errorf("[Daemon]scheduled start %s failed, %s", v.Name, err.Error())
+	}
+}
+
+// scheduledStop 按任务配置的停止信号停止全部实例
+func (d *daemon) scheduledStop(v *model.Daemon) {
+	if v.Status != model.StatusRunning && v.Status != model.StatusRestarting && v.Status != model.StatusWaiting && v.Status != model.StatusCrashLoop {
+		return
+	}
+	Zap.Sugar().Infof("[Daemon]scheduled stop %s", v.Name)
+	d.stopDaemon(v.ID, model.DaemonTriggerSchedule, 0)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"task/model"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 20, 30, 0, time.FixedZone("CST", 8*3600))
+	cases := []struct {
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{"0 0 9 * * ?", time.Date(2026, 10, 20, 9, 0, 0, 0, now.Location()), false},
+		{"0 0 18 * * ?", time.Date(2026, 10, 19, 18, 0, 0, 0, now.Location()), false},
+		{"0 0 9 * *", time.Time{}, true},
+		{"0 0 9 31 2 ?", time.Time{}, true},
+		// 以下表达式会使解析panic
+		{"0 0 9 * * *", time.Time{}, true},
+		{"a b c d e f", time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := parseCron(c.expr, now)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: got error %v", c.expr, err)
+		}
+		if !c.wantErr && !got.Equal(c.want) {
+			t.Fatalf("%s: got %s, want %s", c.expr, got, c.want)
+		}
+		if next := nextCronTime(c.expr, now); next.IsZero() != c.wantErr {
+			t.Fatalf("%s: next time %s", c.expr, next)
+		}
+	}
+	if !nextCronTime("", now).IsZero() {
+		t.Fatal("empty expression should have no next time")
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	cases := []struct {
+		name      string
+		startCron string
+		stopCron  string
+		wantErr   string
+	}{
+		{"no window", "", "", ""},
+		{"start only", "0 0 9 * * ?", "", ""},
+		{"start and stop", "0 0 9 * * ?", "0 0 18 * * ?", ""},
+		{"invalid start", "0 0 9 * * *", "0 0 18 * * ?", "自动开启的时间表达式不合法"},
+		{"invalid stop", "0 0 9 * * ?", "0 0 18", "自动停止的时间表达式不合法"},
+	}
+	for _, c := range cases {
+		err := checkSchedule(&model.Daemon{StartCron: c.startCron, StopCron: c.stopCron})
+		if c.wantErr == "" && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.wantErr != "" && (err == nil || err.Error() != c.wantErr) {
+			t.Fatalf("%s: got %v, want %s", c.name, err, c.wantErr)
+		}
+	}
+}
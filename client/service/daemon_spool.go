@@ -0,0 +1,189 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"task/client/config"
+	"task/pkg/proto"
+	"time"
+)
+
+const (
+	spoolReadSize     = 32 * 1024
+	spoolPollInterval = 200 * time.Millisecond
+	spoolSaveInterval = 5 * time.Second
+	// fallocate的FALLOC_FL_KEEP_SIZE|FALLOC_FL_PUNCH_HOLE，释放已读取部分占用的磁盘空间
+	spoolPunchHole = 0x01 | 0x02
+)
+
+// daemonSpool 进程的输出直接写入文件而不是管道，客户端读取后写入日志，客户端退出时进程不受影响
+type daemonSpool struct {
+	path   string
+	file   *os.File
+	offset int64
+	writer *daemonLogWriter
+	save   func(offset int64)
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// createSpool 新启动进程时清空输出文件，返回交给子进程写入的文件
+func createSpool(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0644)
+}
+
+// openSpool 从offset开始读取输出文件，接管进程时沿用上次记录的位置
+func openSpool(path string, offset int64, writer *daemonLogWriter, save func(offset int64)) (*daemonSpool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &daemonSpool{
+		path:   path,
+		file:   f,
+		offset: offset,
+		writer: writer,
+		save:   save,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *daemonSpool) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(spoolPollInterval)
+	defer ticker.Stop()
+	saved, savedOffset := time.Now(), s.offset
+	for {
+		s.read()
+		if s.offset != savedOffset && time.Now().Sub(saved) >= spoolSaveInterval {
+			s.save(s.offset)
+			saved, savedOffset = time.Now(), s.offset
+		}
+		select {
+		case <-s.stop:
+			s.read()
+			s.writer.flush()
+			if s.offset != savedOffset {
+				s.save(s.offset)
+			}
+			_ = s.file.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// read 读取到文件末尾，并释放已读取部分的磁盘空间
+func (s *daemonSpool) read() {
+	buf := make([]byte, spoolReadSize)
+	start := s.offset
+	for {
+		n, _ := s.file.ReadAt(buf, s.offset)
+		if n > 0 {
+			_, _ = s.writer.Write(buf[:n])
+			s.offset += int64(n)
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	if s.offset > start {
+		_ = syscall.Fallocate(int(s.file.Fd()), spoolPunchHole, 0, s.offset)
+	}
+}
+
+// close 读取剩余的输出后停止，可重复调用
+func (s *daemonSpool) close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// tail 从记录的位置开始读取实例两个输出流的暂存文件
+func (j *daemonJob) tail(stdoutOffset, stderrOffset int64) error {
+	streams := []struct {
+		stream string
+		offset int64
+		column string
+	}{
+		{proto.LogStreamStdout, stdoutOffset, "stdout_offset"},
+		{proto.LogStreamStderr, stderrOffset, "stderr_offset"},
+	}
+	j.spoolMux.Lock()
+	defer j.spoolMux.Unlock()
+	for _, i := range streams {
+		column := i.column
+		s, err := openSpool(config.DaemonSpoolPath(j.value.ID, j.instance, i.stream), i.offset, &daemonLogWriter{job: j, stream: i.stream}, func(offset int64) {
+			_ = j.updateInstance(map[string]interface{}{column: offset})
+		})
+		if err != nil {
+			for _, s := range j.spools {
+				s.close()
+			}
+			j.spools = nil
+			return err
+		}
+		j.spools = append(j.spools, s)
+	}
+	return nil
+}
+
+// closeSpools 读取剩余的输出并记录位置，进程仍在运行时下次可从该位置继续读取
+func (j *daemonJob) closeSpools() {
+	j.spoolMux.Lock()
+	spools := j.spools
+	j.spools = nil
+	j.spoolMux.Unlock()
+	for _, s := range spools {
+		s.close()
+	}
+}
+
+// removeSpools 进程退出后删除暂存文件
+func (j *daemonJob) removeSpools() {
+	for _, stream := range []string{proto.LogStreamStdout, proto.LogStreamStderr} {
+		_ = os.Remove(config.DaemonSpoolPath(j.value.ID, j.instance, stream))
+	}
+}
+
+// procStartTime 读取进程的启动时间(开机后的时钟周期数)，与pid一起识别进程，避免pid被复用后误认
+func procStartTime(pid int) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0, errors.New("invalid stat")
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return 0, errors.New("invalid stat")
+	}
+	// 僵尸进程已经退出，只是还未被回收
+	if fields[0] == "Z" {
+		return 0, errors.New("zombie process")
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	st, err := procStartTime(pid)
+	return err == nil && st == startTime
+}
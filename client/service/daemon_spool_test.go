@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestProcessAlive(t *testing.T) {
+	startTime, err := procStartTime(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !processAlive(os.Getpid(), startTime) {
+		t.Fatal("current process should be alive")
+	}
+	// pid被复用后启动时间不同
+	if processAlive(os.Getpid(), startTime+1) {
+		t.Fatal("process with another start time should not be alive")
+	}
+	if processAlive(0, 0) || processAlive(-1, 0) {
+		t.Fatal("invalid pid should not be alive")
+	}
+	cmd := exec.Command("sleep", "30")
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	startTime, _ = procStartTime(cmd.Process.Pid)
+	_ = cmd.Process.Kill()
+	// 未回收的僵尸进程视为已退出
+	deadline := time.Now().Add(3 * time.Second)
+	for processAlive(cmd.Process.Pid, startTime) {
+		if time.Now().After(deadline) {
+			t.Fatal("killed process should not be alive")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	_ = cmd.Wait()
+}
+
+func TestDaemonSpool(t *testing.T) {
+	removeDaemonLog(94)
+	j := &daemonJob{value: &model.Daemon{ID: 94, Name: "spool"}}
+	if err := j.setLogFile(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = j.logFile.Close()
+	}()
+	path := config.DaemonSpoolPath(94, 0, proto.LogStreamStdout)
+	f, err := createSpool(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, _ = f.WriteString("skipped\nfirst\nsec")
+	var saved []int64
+	s, err := openSpool(path, int64(len("skipped\n")), &daemonLogWriter{job: j, stream: proto.LogStreamStdout}, func(offset int64) {
+		saved = append(saved, offset)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("ond\nthird")
+	time.Sleep(2 * spoolPollInterval)
+	s.close()
+	s.close()
+	size := int64(len("skipped\nfirst\nsecond\nthird"))
+	if len(saved) != 1 || saved[0] != size || s.offset != size {
+		t.Fatalf("got saved offsets %v, offset %d, want %d", saved, s.offset, size)
+	}
+	got := daemonLogLines(t, j.logPath)
+	want := []string{"[stdout] first", "[stdout] second", "[stdout] third"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	// 重新启动时清空暂存文件
+	if f, err = createSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := f.Stat()
+	_ = f.Close()
+	if fi.Size() != 0 {
+		t.Fatalf("got spool size %d, want 0", fi.Size())
+	}
+	if _, err = openSpool(path+".missing", 0, nil, nil); err == nil {
+		t.Fatal("missing spool should fail")
+	}
+}
+
+// startDetached 模拟客户端重启前启动的进程，输出写入暂存文件
+func startDetached(t *testing.T, ID uint, script string) *model.DaemonInstance {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	for i, stream := range []string{proto.LogStreamStdout, proto.LogStreamStderr} {
+		f, err := createSpool(config.DaemonSpoolPath(ID, 0, stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		if i == 0 {
+			cmd.Stdout = f
+		} else {
+			cmd.Stderr = f
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// 进程不是接管者的子进程，由测试负责回收
+	go func() {
+		_ = cmd.Wait()
+	}()
+	startTime, err := procStartTime(cmd.Process.Pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.DaemonInstance{DaemonID: ID, Pid: cmd.Process.Pid, ProcStartTime: startTime, StartTime: uint(time.Now().Unix())}
+}
+
+func TestAttach(t *testing.T) {
+	removeDaemonLog(95)
+	adopt := startDetached(t, 95, "echo before; sleep 0.5; echo after; echo err >&2; sleep 0.5")
+	time.Sleep(200 * time.Millisecond)
+	// 上次已读取到before之后
+	adopt.StdoutOffset = int64(len("before\n"))
+	j := &daemonJob{value: &model.Daemon{ID: 95, Name: "attach"}, ctx: context.Background()}
+	err := j.attach(adopt)
+	if err == nil || err.Error() != "进程已退出，退出码未知" {
+		t.Fatalf("got %v", err)
+	}
+	// 两个输出流分别读取，不保证先后顺序
+	got := daemonLogLines(t, j.logPath)
+	sort.Strings(got)
+	want := []string{"[stderr] err", "[stdout] after"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if j.pid.Load() != 0 {
+		t.Fatal("pid should be cleared after exit")
+	}
+	if _, err = os.Stat(config.DaemonSpoolPath(95, 0, proto.LogStreamStdout)); !os.IsNotExist(err) {
+		t.Fatalf("spool should be removed, got %v", err)
+	}
+
+	// 停止接管的进程时按停止信号结束整个进程组
+	adopt = startDetached(t, 96, "sleep 30 & wait")
+	ctx, cancel := context.WithCancel(context.Background())
+	j = &daemonJob{value: &model.Daemon{ID: 96, Name: "attach stop"}, ctx: ctx}
+	done := make(chan error)
+	go func() {
+		done <- j.attach(adopt)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if j.pid.Load() != int64(adopt.Pid) {
+		t.Fatalf("got pid %d, want %d", j.pid.Load(), adopt.Pid)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		_ = syscall.Kill(-adopt.Pid, syscall.SIGKILL)
+		t.Fatal("adopted process not stopped")
+	}
+}
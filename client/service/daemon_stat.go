@@ -0,0 +1,219 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"task/client/config"
+	"task/pkg/proto"
+	"time"
+)
+
+// clockTicks /proc中cpu时间的单位，Linux下USER_HZ固定为100
+const clockTicks = 100
+
+type daemonStats struct {
+	series map[daemonKey]*daemonStatSeries
+	mux    sync.Mutex
+}
+
+type daemonStatSeries struct {
+	pid      int
+	ticks    map[int]uint64
+	lastTime time.Time
+	samples  []*proto.DaemonStat
+}
+
+// procStat /proc/<pid>/stat 中用到的字段
+type procStat struct {
+	pid     int
+	ppid    int
+	session int
+	ticks   uint64
+	threads int
+	rss     uint64
+}
+
+func newDaemonStats() *daemonStats {
+	return &daemonStats{
+		series: make(map[daemonKey]*daemonStatSeries),
+	}
+}
+
+func (d *daemon) sample() {
+	ticker := time.NewTicker(time.Duration(config.DaemonStatInterval()) * time.Second)
+	for {
+		<-ticker.C
+		pids := make(map[daemonKey]int)
+		d.mux.Lock()
+		for k, j := range d.jobs {
+			if pid := int(j.pid.Load()); pid > 0 {
+				pids[k] = pid
+			}
+		}
+		d.mux.Unlock()
+		d.stats.sample(pids)
+	}
+}
+
+// sample 采集每个实例进程树的资源占用，已停止的实例清除历史
+func (s *daemonStats) sample(pids map[daemonKey]int) {
+	procs := readProcs()
+	children := make(map[int][]int)
+	sessions := make(map[int][]int)
+	for _, p := range procs {
+		children[p.ppid] = append(children[p.ppid], p.pid)
+		sessions[p.session] = append(sessions[p.session], p.pid)
+	}
+	now := time.Now()
+	history := config.DaemonStatHistory()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for k := range s.series {
+		if _, ok := pids[k]; !ok {
+			delete(s.series, k)
+		}
+	}
+	for k, pid := range pids {
+		series, ok := s.series[k]
+		if !ok || series.pid != pid {
+			series = &daemonStatSeries{pid: pid}
+			s.series[k] = series
+		}
+		stat := &proto.DaemonStat{Time: uint(now.Unix())}
+		ticks := make(map[int]uint64)
+		var delta uint64
+		for _, p := range processTree(pid, children, sessions[pid]) {
+			ps, ok := procs[p]
+			if !ok {
+				continue
+			}
+			stat.Procs++
+			stat.Rss += ps.rss
+			stat.Threads += ps.threads
+			stat.Fds += countFds(p)
+			ticks[p] = ps.ticks
+			// 新出现的进程没有上一次的采样，不计入本次cpu
+			if prev, ok := series.ticks[p]; ok && ps.ticks >= prev {
+				delta += ps.ticks - prev
+			}
+		}
+		if !series.lastTime.IsZero() {
+			elapsed := now.Sub(series.lastTime).Seconds()
+			if elapsed > 0 {
+				stat.Cpu = float64(delta) / clockTicks / elapsed * 100
+			}
+		}
+		series.ticks = ticks
+		series.lastTime = now
+		series.samples = append(series.samples, stat)
+		if len(series.samples) > history {
+			series.samples = series.samples[len(series.samples)-history:]
+		}
+	}
+}
+
+func (s *daemonStats) get(IDS []uint) []*proto.DaemonInstanceStat {
+	want := make(map[uint]bool, len(IDS))
+	for _, ID := range IDS {
+		want[ID] = true
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	stats := make([]*proto.DaemonInstanceStat, 0)
+	for k, series := range s.series {
+		if !want[k.ID] {
+			continue
+		}
+		samples := make([]*proto.DaemonStat, len(series.samples))
+		copy(samples, series.samples)
+		stats = append(stats, &proto.DaemonInstanceStat{
+			DaemonID: k.ID,
+			Instance: k.Instance,
+			Pid:      series.pid,
+			Samples:  samples,
+		})
+	}
+	sort.Slice(stats, func(i, k int) bool {
+		if stats[i].DaemonID != stats[k].DaemonID {
+			return stats[i].DaemonID < stats[k].DaemonID
+		}
+		return stats[i].Instance < stats[k].Instance
+	})
+	return stats
+}
+
+// processTree 返回进程及其所有子进程，任务以独立会话启动，脱离父进程的同会话进程也计算在内
+func processTree(pid int, children map[int][]int, session []int) []int {
+	seen := map[int]bool{pid: true}
+	tree := []int{pid}
+	for _, p := range session {
+		if !seen[p] {
+			seen[p] = true
+			tree = append(tree, p)
+		}
+	}
+	for i := 0; i < len(tree); i++ {
+		for _, c := range children[tree[i]] {
+			if !seen[c] {
+				seen[c] = true
+				tree = append(tree, c)
+			}
+		}
+	}
+	return tree
+}
+
+func readProcs() map[int]*procStat {
+	procs := make(map[int]*procStat)
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return procs
+	}
+	pageSize := uint64(os.Getpagesize())
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// 进程名可能包含空格和括号，从最后一个右括号之后开始解析
+		i := strings.LastIndexByte(string(b), ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(string(b[i+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		ppid, _ := strconv.Atoi(fields[1])
+		session, _ := strconv.Atoi(fields[3])
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		threads, _ := strconv.Atoi(fields[17])
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+		procs[pid] = &procStat{
+			pid:     pid,
+			ppid:    ppid,
+			session: session,
+			ticks:   utime + stime,
+			threads: threads,
+			rss:     rss * pageSize,
+		}
+	}
+	return procs
+}
+
+func countFds(pid int) int {
+	entries, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
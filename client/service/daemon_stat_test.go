@@ -0,0 +1,112 @@
+package service
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"task/client/config"
+	"testing"
+	"time"
+)
+
+func TestProcessTree(t *testing.T) {
+	cases := []struct {
+		name     string
+		pid      int
+		children map[int][]int
+		session  []int
+		want     []int
+	}{
+		{"single", 10, nil, nil, []int{10}},
+		{"children", 10, map[int][]int{10: {11, 12}, 11: {13}, 1: {10, 20}}, nil, []int{10, 11, 12, 13}},
+		{"detached in session", 10, map[int][]int{10: {11}, 1: {14}, 14: {15}}, []int{10, 14}, []int{10, 14, 11, 15}},
+		{"cycle", 10, map[int][]int{10: {11}, 11: {10}}, nil, []int{10, 11}},
+	}
+	for _, c := range cases {
+		got := processTree(c.pid, c.children, c.session)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+			}
+		}
+	}
+}
+
+func TestReadProcs(t *testing.T) {
+	// 进程名包含空格和括号时也要正确解析
+	name := filepath.Join(t.TempDir(), "a (b) c")
+	if err := os.Symlink("/bin/sleep", name); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(name, "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	p, ok := readProcs()[cmd.Process.Pid]
+	if !ok {
+		t.Fatal("process not found")
+	}
+	if p.session != cmd.Process.Pid || p.threads != 1 || p.rss == 0 {
+		t.Fatalf("got %+v", p)
+	}
+	if countFds(cmd.Process.Pid) == 0 {
+		t.Fatal("no fds counted")
+	}
+}
+
+func TestDaemonStatsSample(t *testing.T) {
+	config.GetSection("DAEMON_STAT").Key("HISTORY").SetValue("2")
+	t.Cleanup(func() {
+		config.GetSection("DAEMON_STAT").Key("HISTORY").SetValue("")
+	})
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30 & wait")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = cmd.Wait()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	s := newDaemonStats()
+	k := daemonKey{ID: 93, Instance: 1}
+	for i := 0; i < 3; i++ {
+		s.sample(map[daemonKey]int{k: cmd.Process.Pid})
+	}
+	stats := s.get([]uint{93, 94})
+	if len(stats) != 1 || stats[0].DaemonID != 93 || stats[0].Instance != 1 || stats[0].Pid != cmd.Process.Pid {
+		t.Fatalf("got %+v", stats)
+	}
+	if len(stats[0].Samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(stats[0].Samples))
+	}
+	for _, stat := range stats[0].Samples {
+		if stat.Procs != 3 || stat.Threads < 3 || stat.Rss == 0 || stat.Fds == 0 || stat.Cpu < 0 {
+			t.Fatalf("got %+v", stat)
+		}
+	}
+	if got := s.get([]uint{94}); len(got) != 0 {
+		t.Fatalf("got stats of other daemons %+v", got)
+	}
+
+	// 进程重启后重新采集，停止后清除
+	s.sample(map[daemonKey]int{k: cmd.Process.Pid + 100000})
+	if stats = s.get([]uint{93}); len(stats[0].Samples) != 1 || stats[0].Samples[0].Procs != 0 {
+		t.Fatalf("restarted: got %+v", stats[0].Samples)
+	}
+	s.sample(nil)
+	if stats = s.get([]uint{93}); len(stats) != 0 {
+		t.Fatalf("stopped: got %+v", stats)
+	}
+}
@@ -0,0 +1,372 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"task/client/config"
+	"task/model"
+	"task/pkg/proto"
+	"testing"
+	"time"
+)
+
+func TestRestartDelay(t *testing.T) {
+	cases := []struct {
+		name     string
+		backoff  uint
+		maxDelay uint
+		retryNum uint
+		want     time.Duration
+	}{
+		{"default first retry", 0, 0, 1, time.Second},
+		{"default doubles", 0, 0, 4, 8 * time.Second},
+		{"default capped at 5 minutes", 0, 0, 20, 5 * time.Minute},
+		{"first retry", 3, 60, 1, 3 * time.Second},
+		{"zero retry uses backoff", 3, 60, 0, 3 * time.Second},
+		{"second retry", 3, 60, 2, 6 * time.Second},
+		{"fifth retry", 3, 60, 5, 48 * time.Second},
+		{"capped", 3, 60, 6, time.Minute},
+		{"capped for large retries", 3, 60, 1000, time.Minute},
+		{"backoff above max", 90, 60, 1, time.Minute},
+	}
+	for _, c := range cases {
+		j := &daemonJob{value: &model.Daemon{RestartBackoff: c.backoff, RestartMaxDelay: c.maxDelay}}
+		if got := j.restartDelay(c.retryNum); got != c.want {
+			t.Fatalf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReachRestartLimit(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		limit    uint
+		retryNum uint
+		want     bool
+	}{
+		{"always without limit", model.RestartAlways, 0, 1000, false},
+		{"always within limit", model.RestartAlways, 3, 3, false},
+		{"always over limit", model.RestartAlways, 3, 4, true},
+		{"on-failure within limit", model.RestartOnFailure, 3, 3, false},
+		{"on-failure over limit", model.RestartOnFailure, 3, 4, true},
+		{"on-failure without retries", model.RestartOnFailure, 0, 1, true},
+	}
+	for _, c := range cases {
+		j := &daemonJob{value: &model.Daemon{RestartPolicy: c.policy, FailedRestartNum: c.limit}}
+		if got := j.reachRestartLimit(c.retryNum); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	cases := []struct {
+		name string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"", syscall.SIGHUP, true},
+		{"usr1", syscall.SIGUSR1, true},
+		{"SIGTERM", syscall.SIGTERM, true},
+		{"KILL", syscall.SIGKILL, false},
+		{"NOPE", syscall.SIGHUP, false},
+	}
+	for _, c := range cases {
+		got, err := reloadSignal(c.name)
+		if got != c.want || (err == nil) != c.ok {
+			t.Fatalf("%q: got %s %v", c.name, got, err)
+		}
+	}
+}
+
+func TestJobReload(t *testing.T) {
+	removeDaemonLog(37)
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process.Pid
+	j := &daemonJob{value: &model.Daemon{ID: 37, Name: "reload", ReloadCommand: "printenv TASK_PID"}, ctx: context.Background()}
+	j.pid.Store(int64(pid))
+	// 重载命令可以通过环境变量得到主进程ID
+	msg, err := j.reload()
+	if err != nil || msg != strconv.Itoa(pid) {
+		t.Fatalf("command: got %q %v", msg, err)
+	}
+	j.value.ReloadCommand = ""
+	j.value.ReloadSignal = "USR1"
+	msg, err = j.reload()
+	if err != nil || msg != fmt.Sprintf("向进程%d发送信号%s", pid, syscall.SIGUSR1) {
+		t.Fatalf("signal: got %q %v", msg, err)
+	}
+	_ = cmd.Wait()
+	if ws := cmd.ProcessState.Sys().(syscall.WaitStatus); ws.Signal() != syscall.SIGUSR1 {
+		t.Fatalf("got signal %s, want %s", ws.Signal(), syscall.SIGUSR1)
+	}
+	_ = j.logFile.Close()
+	// 重载的输出和发送的信号记录在日志中
+	lines := daemonLogLines(t, j.logPath)
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "["+proto.LogStreamReload+"] ") {
+			t.Fatalf("got log line %q", line)
+		}
+	}
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, msg) {
+		t.Fatalf("got last log line %q, want %q", last, msg)
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	t.Cleanup(func() {
+		model.Task().Where("1=1").Delete(&model.DaemonLog{})
+	})
+	pid := cmd.Process.Pid
+	cases := []struct {
+		name        string
+		value       *model.Daemon
+		pid         int
+		wantSuccess bool
+		wantMessage string
+		wantReason  string
+	}{
+		{"command", &model.Daemon{ID: 31, Name: "command", ReloadCommand: "echo reloaded"}, pid, true, "reloaded", "重载成功,reloaded"},
+		{"command failed", &model.Daemon{ID: 32, Name: "failed", ReloadCommand: "false"}, pid, false, "重载命令执行失败,exit status 1", "重载失败,重载命令执行失败,exit status 1"},
+		{"not running", &model.Daemon{ID: 33, Name: "stopped", ReloadCommand: "echo reloaded"}, 0, false, "进程未运行", "重载失败,进程未运行"},
+		{"invalid signal", &model.Daemon{ID: 34, Name: "invalid", ReloadSignal: "NOPE"}, pid, false, "重载信号不合法", "重载失败,重载信号不合法"},
+		{"signal", &model.Daemon{ID: 35, Name: "signal", ReloadSignal: "HUP"}, pid, true, fmt.Sprintf("向进程%d发送信号hangup", pid), fmt.Sprintf("重载成功,向进程%d发送信号hangup", pid)},
+	}
+	d := newDaemon()
+	for _, c := range cases {
+		j := &daemonJob{daemon: d, value: c.value, instance: 1, ctx: context.Background()}
+		j.pid.Store(int64(c.pid))
+		d.jobs[j.key()] = j
+		results := d.reload(c.value, nil, 7)
+		if len(results) != 1 || results[0].Success != c.wantSuccess || results[0].Message != c.wantMessage {
+			t.Fatalf("%s: got result %+v", c.name, results[0])
+		}
+		var logs []*model.DaemonLog
+		model.Task().Where("daemon_id=?", c.value.ID).Find(&logs)
+		if len(logs) != 1 {
+			t.Fatalf("%s: got %d history rows, want 1", c.name, len(logs))
+		}
+		l := logs[0]
+		if l.Event != model.DaemonEventReload || l.Trigger != model.DaemonTriggerUser || l.UserID != 7 || l.Instance != 1 || l.Pid != c.pid || l.Reason != c.wantReason {
+			t.Fatalf("%s: got history %+v", c.name, l)
+		}
+	}
+	results := d.reload(&model.Daemon{ID: 36}, nil, 7)
+	var n int64
+	model.Task().Model(&model.DaemonLog{}).Where("daemon_id=?", 36).Count(&n)
+	if len(results) != 1 || results[0].Message != "任务未运行" || n != 0 {
+		t.Fatalf("reload without running instances got %+v and %d history rows", results[0], n)
+	}
+}
+
+func TestAddJob(t *testing.T) {
+	d := newDaemon()
+	v := &model.Daemon{ID: 41, Name: "add"}
+	running := &daemonJob{value: v, instance: 1, cancel: func() {}}
+	if err := d.addJob(running); err != nil || len(d.ready) != 1 {
+		t.Fatalf("new instance should be queued, got %v", err)
+	}
+	<-d.ready
+	// 实例已在运行时不重复启动
+	if err := d.addJob(&daemonJob{value: v, instance: 1}); err != nil || len(d.ready) != 0 {
+		t.Fatalf("running instance should not be queued again, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		d.mux.Lock()
+		d.stopJob(running.key(), running, model.DaemonTriggerUser, 7)
+		d.mux.Unlock()
+		close(done)
+	}()
+	// 与停止并发启动，停止后返回正在停止
+	for {
+		err := d.addJob(&daemonJob{value: v, instance: 1})
+		if errors.Is(err, errStopping) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if len(d.ready) != 0 {
+		t.Fatal("stopping instance should not be queued")
+	}
+	d.mux.Lock()
+	d.closing = true
+	d.mux.Unlock()
+	if err := d.addJob(&daemonJob{value: v, instance: 2}); !errors.Is(err, errClosing) {
+		t.Fatalf("got %v, want %v", err, errClosing)
+	}
+}
+
+func TestInstanceEnv(t *testing.T) {
+	cases := []struct {
+		name     string
+		numprocs uint
+		instance uint
+		env      []string
+		want     []string
+		wantName string
+		wantLog  string
+	}{
+		{"single", 0, 0, []string{"A=1"}, []string{"A=1", "TASK_INSTANCE_INDEX=0", "TASK_NUMPROCS=1"}, "worker", "61.log"},
+		{"first of many", 3, 0, []string{"A=1"}, []string{"A=1", "TASK_INSTANCE_INDEX=0", "TASK_NUMPROCS=3"}, "worker#0", "61.log"},
+		{"third of many", 3, 2, []string{"A=1"}, []string{"A=1", "TASK_INSTANCE_INDEX=2", "TASK_NUMPROCS=3"}, "worker#2", "61_2.log"},
+	}
+	for _, c := range cases {
+		j := &daemonJob{value: &model.Daemon{ID: 61, Name: "worker", Numprocs: c.numprocs}, instance: c.instance}
+		if got := j.instanceEnv(c.env); strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: got env %v, want %v", c.name, got, c.want)
+		}
+		if got := j.name(); got != c.wantName {
+			t.Fatalf("%s: got name %s, want %s", c.name, got, c.wantName)
+		}
+		if got := filepath.Base(config.DaemonInstanceLogPath(61, c.instance, "2026-10-19")); got != c.wantLog {
+			t.Fatalf("%s: got log %s, want %s", c.name, got, c.wantLog)
+		}
+	}
+	if env := (&daemonJob{value: &model.Daemon{}}).instanceEnv(nil); len(env) != len(os.Environ())+2 {
+		t.Fatal("instances without env should inherit the client env")
+	}
+}
+
+func TestSyncStatus(t *testing.T) {
+	type instance struct {
+		status       string
+		startTime    uint
+		endTime      uint
+		failed       uint
+		failedReason string
+	}
+	cases := []struct {
+		name             string
+		instances        []instance
+		wantStatus       string
+		wantStartTime    uint
+		wantEndTime      uint
+		wantFailedReason string
+	}{
+		{"any running", []instance{{model.StatusRunning, 200, 0, 0, ""}, {model.StatusRunning, 100, 0, 0, ""}, {model.StatusStopped, 0, 300, 0, ""}}, model.StatusRunning, 100, 0, ""},
+		{"restarting", []instance{{model.StatusRestarting, 100, 0, 0, ""}, {model.StatusStopped, 0, 300, 0, ""}}, model.StatusRestarting, 100, 0, ""},
+		{"all stopped", []instance{{model.StatusStopped, 0, 300, 1, "exit status 1"}, {model.StatusStopped, 0, 400, 0, ""}}, model.StatusStopped, 0, 400, "实例0,exit status 1"},
+	}
+	d := newDaemon()
+	for k, c := range cases {
+		v := &model.Daemon{ID: uint(70 + k), Name: c.name, Numprocs: uint(len(c.instances)), Status: model.StatusRunning}
+		if err := model.Task().Create(v).Error; err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range c.instances {
+			err := model.Task().Create(&model.DaemonInstance{DaemonID: v.ID, Instance: uint(i), Status: s.status, StartTime: s.startTime, EndTime: s.endTime, Failed: s.failed, FailedReason: s.failedReason}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		d.syncStatus(v.ID)
+		var got model.Daemon
+		model.Task().First(&got, v.ID)
+		if got.Status != c.wantStatus || got.FailedReason != c.wantFailedReason {
+			t.Fatalf("%s: got status %s failed reason %q", c.name, got.Status, got.FailedReason)
+		}
+		if (c.wantStatus == model.StatusStopped && got.EndTime != c.wantEndTime) || (c.wantStatus != model.StatusStopped && got.StartTime != c.wantStartTime) {
+			t.Fatalf("%s: got start time %d end time %d", c.name, got.StartTime, got.EndTime)
+		}
+		model.Task().Where("daemon_id=?", v.ID).Delete(&model.DaemonInstance{})
+		model.Task().Delete(v)
+	}
+}
+
+func TestStopSignal(t *testing.T) {
+	cases := []struct {
+		name string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"", syscall.SIGTERM, true},
+		{"term", syscall.SIGTERM, true},
+		{"SIGINT", syscall.SIGINT, true},
+		{" sigkill ", syscall.SIGKILL, true},
+		{"USR2", syscall.SIGUSR2, true},
+		{"STOP", syscall.SIGTERM, false},
+		{"15", syscall.SIGTERM, false},
+	}
+	for _, c := range cases {
+		got, err := stopSignal(c.name)
+		if got != c.want || (err == nil) != c.ok {
+			t.Fatalf("%q: got %s %v", c.name, got, err)
+		}
+	}
+	if got := stopWait(&model.Daemon{}); got != 10*time.Second {
+		t.Fatalf("default stop wait: got %s", got)
+	}
+	if got := stopWait(&model.Daemon{StopWait: 3}); got != 3*time.Second {
+		t.Fatalf("stop wait: got %s", got)
+	}
+}
+
+func TestStopProcessGroup(t *testing.T) {
+	cases := []struct {
+		name       string
+		script     string
+		stopSignal string
+		stopWait   uint
+		exitCode   int
+		signal     syscall.Signal
+		minWait    time.Duration
+	}{
+		{"term", "sleep 30 & wait", "", 0, -1, syscall.SIGTERM, 0},
+		{"custom signal", "trap 'exit 3' INT; while true; do sleep 0.1; done", "INT", 0, 3, 0, 0},
+		{"kill", "sleep 30 & wait", "KILL", 0, -1, syscall.SIGKILL, 0},
+		{"killed after stop wait", "trap '' TERM; sleep 30 & wait", "", 1, -1, syscall.SIGKILL, time.Second},
+	}
+	for _, c := range cases {
+		j := &daemonJob{value: &model.Daemon{Name: c.name, StopSignal: c.stopSignal, StopWait: c.stopWait}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cmd := j.getCmd(ctx, "sh", "-c", c.script)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		pgid := cmd.Process.Pid
+		time.Sleep(200 * time.Millisecond)
+		sTime := time.Now()
+		cancel()
+		_ = cmd.Wait()
+		elapsed := time.Since(sTime)
+		status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		if status.ExitStatus() != c.exitCode || (c.signal != 0 && status.Signal() != c.signal) {
+			t.Fatalf("%s: got exit code %d signal %s", c.name, status.ExitStatus(), status.Signal())
+		}
+		if elapsed < c.minWait || elapsed > c.minWait+3*time.Second {
+			t.Fatalf("%s: stopped after %s", c.name, elapsed)
+		}
+		// 子进程也在同一进程组中，应一起结束
+		deadline := time.Now().Add(5 * time.Second)
+		for syscall.Kill(-pgid, 0) == nil {
+			if time.Now().After(deadline) {
+				_ = syscall.Kill(-pgid, syscall.SIGKILL)
+				t.Fatalf("%s: process group still alive", c.name)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"task/client/config"
+	"task/pkg/helper"
+	"time"
+)
+
+const (
+	hookPre  = "pre-hook"
+	hookPost = "post-hook"
+)
+
+// hookContext 前置和后置钩子的执行时间不超过HOOK_TIMEOUT，超时后终止钩子进程组
+func hookContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if t := config.HookTimeout(); t > 0 {
+		return context.WithTimeout(parent, time.Duration(t)*time.Second)
+	}
+	return context.WithCancel(parent)
+}
+
+// runHook 以任务相同的目录、用户和环境变量执行钩子命令，返回合并后的输出
+func runHook(ctx context.Context, command string, dir string, username string, env []string) ([]byte, error) {
+	args := strings.Split(command, " ")
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	cmd.SysProcAttr.Setsid = true
+	// 钩子独立成会话，取消时连同其子进程一起终止，子进程持有输出管道时也不会一直阻塞
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	if dir != "" && helper.FileExist(dir) {
+		cmd.Dir = dir
+	}
+	if len(env) > 0 {
+		cmd.Env = env
+	}
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err == nil {
+			uid, _ := strconv.Atoi(u.Uid)
+			gid, _ := strconv.Atoi(u.Gid)
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+		}
+	}
+	return cmd.CombinedOutput()
+}
+
+func hookOutcomeEnv(env []string, status string, exitCode int, duration time.Duration) []string {
+	outcome := make([]string, 0, len(env)+3)
+	outcome = append(outcome, env...)
+	return append(outcome,
+		"TASK_STATUS="+status,
+		"TASK_EXIT_CODE="+strconv.Itoa(exitCode),
+		"TASK_DURATION="+strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+	)
+}
+
+func hookLog(name string, out []byte, err error) string {
+	if len(out) == 0 && err == nil {
+		return ""
+	}
+	l := fmt.Sprintf("[%s]\n%s", name, out)
+	if err != nil {
+		l += fmt.Sprintf("[%s] %s\n", name, err.Error())
+	}
+	return l
+}
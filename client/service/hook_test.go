@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"task/client/config"
+	"testing"
+	"time"
+)
+
+func TestRunHookTimeout(t *testing.T) {
+	key := config.GetSection("APP").Key("HOOK_TIMEOUT")
+	t.Cleanup(func() {
+		key.SetValue("60")
+	})
+	key.SetValue("1")
+	ctx, cancel := hookContext(context.Background())
+	defer cancel()
+	start := time.Now()
+	out, err := runHook(ctx, "sleep 10", "", "", nil)
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf("hook should be killed after the timeout, got %q %v in %s", out, err, time.Since(start))
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	cases := []struct {
+		name    string
+		command string
+		env     []string
+		want    string
+		wantErr bool
+	}{
+		{"output", "echo hello", nil, "hello\n", false},
+		{"env", "printenv TASK_STATUS", hookOutcomeEnv([]string{"PATH=/usr/bin:/bin"}, "Success", 0, time.Second), "Success\n", false},
+		{"failed", "false", nil, "", true},
+		{"not found", "task-hook-not-found", nil, "", true},
+	}
+	for _, c := range cases {
+		out, err := runHook(context.Background(), c.command, "", "", c.env)
+		if (err != nil) != c.wantErr || string(out) != c.want {
+			t.Fatalf("%s: got %q %v", c.name, out, err)
+		}
+	}
+}
+
+func TestHookOutcomeEnv(t *testing.T) {
+	env := []string{"A=1"}
+	got := hookOutcomeEnv(env, "Error", 2, 1500*time.Millisecond)
+	want := "A=1,TASK_STATUS=Error,TASK_EXIT_CODE=2,TASK_DURATION=1.500"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got %v, want %s", got, want)
+	}
+	if len(env) != 1 {
+		t.Fatal("original env should not be modified")
+	}
+}
+
+func TestHookLog(t *testing.T) {
+	cases := []struct {
+		name string
+		out  string
+		err  error
+		want string
+	}{
+		{"empty", "", nil, ""},
+		{"output", "done\n", nil, "[pre-hook]\ndone\n"},
+		{"failed", "oops\n", context.DeadlineExceeded, "[pre-hook]\noops\n[pre-hook] context deadline exceeded\n"},
+	}
+	for _, c := range cases {
+		if got := hookLog(hookPre, []byte(c.out), c.err); got != c.want {
+			t.Fatalf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"task/client/config"
+	"task/pkg/mrpc"
+	"task/pkg/proto"
+)
+
+// resolveSecretEnv 执行前向管理端换取环境变量中引用的密钥，明文只保留在内存中
+func resolveSecretEnv(env []string) ([]string, []string, error) {
+	names := proto.SecretRefNames(env)
+	if len(names) == 0 {
+		return env, nil, nil
+	}
+	values := make(map[string]string)
+	args := &proto.SecretResolveArgs{
+		Address: config.NodeAddr(),
+		Names:   names,
+	}
+	err := mrpc.Call(config.ManageListenAddr(), "Serve.Secret", context.TODO(), args, &values)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolved := make([]string, 0, len(env))
+	for _, e := range env {
+		resolved = append(resolved, proto.SecretRefReg.ReplaceAllStringFunc(e, func(s string) string {
+			return values[proto.SecretRefReg.FindStringSubmatch(s)[1]]
+		}))
+	}
+	secrets := make([]string, 0, len(values))
+	for _, v := range values {
+		secrets = append(secrets, v)
+	}
+	return resolved, secrets, nil
+}
+
+func maskSecrets(s string, secrets []string) string {
+	for _, v := range secrets {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, proto.SecretMask)
+		}
+	}
+	return s
+}
@@ -4,11 +4,9 @@ import (
 	"bufio"
 	"errors"
 	"gorm.io/gorm"
-	"os"
 	"regexp"
 	"task/client/config"
 	"task/model"
-	"task/pkg/helper"
 	"task/pkg/proto"
 	"time"
 )
@@ -72,10 +70,17 @@ func (cs *CrontabServe) Edit(request proto.CrontabArgs, response *model.Crontab)
 		"status":          model.StatusUnaudited,
 		"next_exec_time":  0,
 		"time_expr":       request.Crontab.TimeExpr,
+		"reboot_delay":    request.Crontab.RebootDelay,
+		"template":        request.Crontab.Template,
 		"timeout":         request.Crontab.Timeout,
 		"timeout_trigger": request.Crontab.TimeoutTrigger,
 		"error_trigger":   request.Crontab.ErrorTrigger,
 		"ding_talk_addr":  request.Crontab.DingTalkAddr,
+		"pre_hook":        request.Crontab.PreHook,
+		"post_hook":       request.Crontab.PostHook,
+		"artifacts":       request.Crontab.Artifacts,
+		"log_max_age":     request.Crontab.LogMaxAge,
+		"log_max_rows":    request.Crontab.LogMaxRows,
 		"update_user_id":  request.UserID,
 		"update_time":     uint(time.Now().Unix()),
 	}).Error
@@ -108,6 +113,14 @@ func (cs *CrontabServe) Start(request proto.CrontabActionArgs, response *[]*mode
 	}
 	var j *crontabJob
 	for _, v := range *response {
+		if v.TimeExpr == model.TimeExprReboot {
+			// 只在客户端启动时执行，开启后等待下次启动
+			model.Task().Model(v).Updates(map[string]interface{}{
+				"status":         model.StatusTiming,
+				"next_exec_time": 0,
+			})
+			continue
+		}
 		j, err = cs.crontab.addJob(&crontabJob{
 			id:       v.ID,
 			timeExpr: v.TimeExpr,
@@ -176,6 +189,9 @@ func (cs *CrontabServe) Exec(request proto.CrontabActionArgs, response *[]*model
 			id:     v.ID,
 			userId: request.UserID,
 		})
+		if errors.Is(err, errClosing) {
+			return err
+		}
 		if err == nil {
 			go j.exec()
 		}
@@ -192,12 +208,32 @@ func (cs *CrontabServe) Kill(request proto.CrontabActionArgs, response *[]*model
 		}
 		return err
 	}
+	if request.RunID != "" {
+		ID, ok := cs.crontab.killRun(request.RunID, request.CrontabIDS)
+		if !ok {
+			return errors.New("执行不存在或已结束")
+		}
+		// 只返回执行所属的任务
+		for _, j := range *response {
+			if j.ID == ID {
+				*response = []*model.Crontab{j}
+				return nil
+			}
+		}
+		*response = nil
+		return nil
+	}
 	for _, j := range *response {
 		cs.crontab.kill(j.ID)
 	}
 	return nil
 }
 
+func (cs *CrontabServe) Running(request *proto.CrontabRunListArgs, response *[]*proto.CrontabRun) error {
+	*response = cs.crontab.runList(request.CrontabID)
+	return nil
+}
+
 func (cs *CrontabServe) Log(request *proto.CrontabLogListArgs, response *proto.CrontabLogListReply) error {
 	m := model.Task().Model(&model.CrontabLog{}).Where("crontab_id=?", request.CrontabID)
 	if request.StartTime > 0 {
@@ -223,6 +259,25 @@ func (cs *CrontabServe) Clean(request *proto.CrontabGetArgs, response *int64) er
 	return m.Delete(&model.CrontabLog{}).Error
 }
 
+// PurgeStats 返回后台清理执行日志的统计
+func (cs *CrontabServe) PurgeStats(request *proto.EmptyArgs, response *proto.CrontabLogPurgeReply) error {
+	cs.crontab.purgeStat.get(response)
+	return nil
+}
+
+func (cs *CrontabServe) Artifacts(request *proto.CrontabArtifactArgs, response *[]*proto.CrontabArtifact) error {
+	list, err := artifactList(request.CrontabID, request.RunID)
+	if err != nil {
+		return err
+	}
+	*response = list
+	return nil
+}
+
+func (cs *CrontabServe) Artifact(request *proto.CrontabArtifactArgs, response *proto.CrontabArtifactChunk) error {
+	return artifactRead(request, response)
+}
+
 type DaemonServe struct {
 	daemon *daemon
 }
@@ -248,6 +303,18 @@ func (ds *DaemonServe) List(request proto.DaemonListArgs, response *proto.Daemon
 }
 
 func (ds *DaemonServe) Add(request proto.DaemonArgs, response *model.Daemon) error {
+	if _, err := stopSignal(request.Daemon.StopSignal); err != nil {
+		return err
+	}
+	if _, err := reloadSignal(request.Daemon.ReloadSignal); err != nil {
+		return err
+	}
+	if err := checkDepends(&request.Daemon); err != nil {
+		return err
+	}
+	if err := checkSchedule(&request.Daemon); err != nil {
+		return err
+	}
 	now := uint(time.Now().Unix())
 	request.Daemon.Status = model.StatusUnaudited
 	request.Daemon.CreateUserID = request.UserID
@@ -265,20 +332,65 @@ func (ds *DaemonServe) Get(request proto.DaemonGetArgs, reply *model.Daemon) err
 }
 
 func (ds *DaemonServe) Edit(request proto.DaemonArgs, response *model.Daemon) error {
-	ds.daemon.delJob(request.Daemon.ID)
+	if _, err := stopSignal(request.Daemon.StopSignal); err != nil {
+		return err
+	}
+	if _, err := reloadSignal(request.Daemon.ReloadSignal); err != nil {
+		return err
+	}
+	if err := checkDepends(&request.Daemon); err != nil {
+		return err
+	}
+	if err := checkSchedule(&request.Daemon); err != nil {
+		return err
+	}
+	ds.daemon.delJob(request.Daemon.ID, request.UserID)
 	defer model.Task().First(response, request.Daemon.ID)
+	model.Task().Where("daemon_id=? and instance>=?", request.Daemon.ID, numprocs(&request.Daemon)).Delete(&model.DaemonInstance{})
 	return model.Task().Model(&model.Daemon{}).Where("id=?", request.Daemon.ID).Updates(map[string]interface{}{
-		"name":               request.Daemon.Name,
-		"status":             model.StatusUnaudited,
-		"start_time":         0,
-		"end_time":           0,
-		"failed_restart_num": request.Daemon.FailedRestartNum,
-		"failed":             0,
-		"failed_reason":      "",
-		"failed_notice":      request.Daemon.FailedNotice,
-		"ding_talk_addr":     request.Daemon.DingTalkAddr,
-		"update_user_id":     request.UserID,
-		"update_time":        uint(time.Now().Unix()),
+		"name":                request.Daemon.Name,
+		"status":              model.StatusUnaudited,
+		"start_time":          0,
+		"end_time":            0,
+		"numprocs":            request.Daemon.Numprocs,
+		"start_priority":      request.Daemon.StartPriority,
+		"depends_on":          request.Daemon.DependsOn,
+		"depends_condition":   request.Daemon.DependsCondition,
+		"depends_timeout":     request.Daemon.DependsTimeout,
+		"start_cron":          request.Daemon.StartCron,
+		"stop_cron":           request.Daemon.StopCron,
+		"stop_signal":         request.Daemon.StopSignal,
+		"reload_signal":       request.Daemon.ReloadSignal,
+		"reload_command":      request.Daemon.ReloadCommand,
+		"stop_wait":           request.Daemon.StopWait,
+		"log_max_size":        request.Daemon.LogMaxSize,
+		"log_max_age":         request.Daemon.LogMaxAge,
+		"log_max_total":       request.Daemon.LogMaxTotal,
+		"failed_restart_num":  request.Daemon.FailedRestartNum,
+		"restart_policy":      request.Daemon.RestartPolicy,
+		"restart_backoff":     request.Daemon.RestartBackoff,
+		"restart_max_delay":   request.Daemon.RestartMaxDelay,
+		"restart_reset_after": request.Daemon.RestartResetAfter,
+		"crash_loop_restarts": request.Daemon.CrashLoopRestarts,
+		"crash_loop_window":   request.Daemon.CrashLoopWindow,
+		"crash_loop_backoff":  request.Daemon.CrashLoopBackoff,
+		"failed":              0,
+		"failed_reason":       "",
+		"failed_notice":       request.Daemon.FailedNotice,
+		"ding_talk_addr":      request.Daemon.DingTalkAddr,
+		"pre_hook":            request.Daemon.PreHook,
+		"post_hook":           request.Daemon.PostHook,
+		"health_check":        request.Daemon.HealthCheck,
+		"health_port":         request.Daemon.HealthPort,
+		"health_path":         request.Daemon.HealthPath,
+		"health_command":      request.Daemon.HealthCommand,
+		"health_interval":     request.Daemon.HealthInterval,
+		"health_timeout":      request.Daemon.HealthTimeout,
+		"health_threshold":    request.Daemon.HealthThreshold,
+		"health_status":       model.HealthUnknown,
+		"health_msg":          "",
+		"update_user_id":      request.UserID,
+		"update_time":         uint(time.Now().Unix()),
 	}).Error
 }
 
@@ -298,8 +410,13 @@ func (ds *DaemonServe) Audit(request proto.DaemonActionArgs, reply *[]*model.Dae
 	return model.Task().Model(&model.Daemon{}).Where("id in (?)", IDS).Update("status", model.StatusOk).Error
 }
 
+// Start 未指定实例时启动整个任务，指定实例时可单独启动已停止的实例
 func (ds *DaemonServe) Start(request proto.DaemonActionArgs, response *[]*model.Daemon) error {
-	m := model.Task().Where("id in (?) and status in (?)", request.DaemonIDS, []string{model.StatusOk, model.StatusStopped})
+	status := []string{model.StatusOk, model.StatusStopped}
+	if len(request.Instances) > 0 {
+		status = append(status, model.StatusRunning, model.StatusRestarting, model.StatusWaiting, model.StatusCrashLoop)
+	}
+	m := model.Task().Where("id in (?) and status in (?)", request.DaemonIDS, status)
 	err := m.Find(response).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -307,31 +424,103 @@ func (ds *DaemonServe) Start(request proto.DaemonActionArgs, response *[]*model.
 		}
 		return err
 	}
-	for _, v := range *response {
-		ds.daemon.addJob(&daemonJob{
-			value: v,
-		})
+	ordered, err := orderDaemons(*response)
+	if err != nil {
+		return err
+	}
+	for _, v := range ordered {
+		err = ds.daemon.addDaemon(v, request.Instances, request.UserID)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Stop 未指定实例时停止整个任务，指定实例时只停止对应实例
 func (ds *DaemonServe) Stop(request proto.DaemonActionArgs, response *[]*model.Daemon) error {
-	err := model.Task().Where("id in (?) and status = ?", request.DaemonIDS, model.StatusRunning).Find(response).Error
+	err := model.Task().Where("id in (?) and status in (?)", request.DaemonIDS, []string{model.StatusRunning, model.StatusRestarting, model.StatusWaiting, model.StatusCrashLoop}).Find(response).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil
 		}
 		return err
 	}
+	if len(request.Instances) > 0 {
+		for _, v := range *response {
+			for _, i := range request.Instances {
+				ds.daemon.delInstance(daemonKey{ID: v.ID, Instance: i}, request.UserID)
+			}
+		}
+		return nil
+	}
+	// 有实例需要等待退出时先标记为停止中，由实例退出时写入已停止
 	var IDS []uint
 	for k, v := range *response {
-		(*response)[k].Status = model.StatusStopped
-		ds.daemon.delJob(v.ID)
-		IDS = append(IDS, v.ID)
+		(*response)[k].Status = model.StatusStopping
+		if len(ds.daemon.delJob(v.ID, request.UserID)) == 0 {
+			(*response)[k].Status = model.StatusStopped
+			IDS = append(IDS, v.ID)
+		}
+	}
+	if len(IDS) == 0 {
+		return nil
 	}
 	return model.Task().Model(&model.Daemon{}).Where("id in (?)", IDS).Update("status", model.StatusStopped).Error
 }
 
+func (ds *DaemonServe) LogSearch(request proto.DaemonLogSearchArgs, response *proto.DaemonLogSearchReply) error {
+	return searchDaemonLog(&request, response)
+}
+
+func (ds *DaemonServe) LogChunk(request proto.DaemonLogArgs, response *proto.DaemonLogChunk) error {
+	return readDaemonLogChunk(&request, response)
+}
+
+func (ds *DaemonServe) History(request *proto.DaemonHistoryArgs, response *proto.DaemonHistoryReply) error {
+	m := model.Task().Model(&model.DaemonLog{}).Where("daemon_id=?", request.DaemonID)
+	if request.Instance != nil {
+		m.Where("instance = ?", *request.Instance)
+	}
+	if request.Event != "" {
+		m.Where("event = ?", request.Event)
+	}
+	if request.StartTime > 0 {
+		m.Where("create_time >= ?", request.StartTime)
+	}
+	if request.EndTime > 0 {
+		m.Where("create_time <= ?", request.EndTime)
+	}
+	m.Count(&response.Total)
+	err := m.Order("id desc").Offset((request.Page - 1) * request.PageSize).Limit(request.PageSize).Find(&response.List).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (ds *DaemonServe) Stat(request proto.DaemonStatArgs, response *[]*proto.DaemonInstanceStat) error {
+	*response = ds.daemon.stats.get(request.DaemonIDS)
+	return nil
+}
+
+func (ds *DaemonServe) Reload(request proto.DaemonActionArgs, response *[]*proto.DaemonReloadResult) error {
+	var daemons []*model.Daemon
+	err := model.Task().Where("id in (?) and status in (?)", request.DaemonIDS, []string{model.StatusRunning, model.StatusRestarting, model.StatusCrashLoop}).Find(&daemons).Error
+	if err != nil {
+		return err
+	}
+	*response = make([]*proto.DaemonReloadResult, 0)
+	for _, v := range daemons {
+		*response = append(*response, ds.daemon.reload(v, request.Instances, request.UserID)...)
+	}
+	return nil
+}
+
+func (ds *DaemonServe) Instances(request proto.DaemonGetArgs, response *[]*model.DaemonInstance) error {
+	return model.Task().Where("daemon_id=?", request.DaemonID).Order("instance asc").Find(response).Error
+}
+
 func (ds *DaemonServe) Del(request proto.DaemonActionArgs, response *[]*model.Daemon) error {
 	m := model.Task().Where("id in (?)", request.DaemonIDS)
 	err := m.Find(response).Error
@@ -342,27 +531,34 @@ func (ds *DaemonServe) Del(request proto.DaemonActionArgs, response *[]*model.Da
 		return err
 	}
 	var IDS []uint
+	var stopping []*daemonJob
 	for _, j := range *response {
-		ds.daemon.delJob(j.ID)
+		stopping = append(stopping, ds.daemon.delJob(j.ID, request.UserID)...)
 		IDS = append(IDS, j.ID)
 	}
+	// 实例退出时会写入停止记录，等待退出后再删除，避免留下无主的记录
+	if !waitStopped(stopping) {
+		Zap.Sugar().Warnf("[Daemon]%v did not stop in time before deletion", IDS)
+	}
+	model.Task().Where("daemon_id in (?)", IDS).Delete(&model.DaemonInstance{})
+	model.Task().Where("daemon_id in (?)", IDS).Delete(&model.DaemonLog{})
 	return model.Task().Where("id in (?)", IDS).Delete(&model.Daemon{}).Error
 }
 
 func (ds *DaemonServe) Log(request proto.DaemonLogArgs, response *proto.DaemonLogReply) error {
-	logPath := config.DaemonLogPath(request.DaemonID, request.Date)
-	if !helper.FileExist(logPath) {
+	logPath := config.DaemonInstanceLogPath(request.DaemonID, request.Instance, request.Date)
+	segments := daemonLogSegments(logPath)
+	if len(segments) == 0 {
 		return errors.New("日志文件不存在")
 	}
-	f, err := os.Open(logPath)
+	f, err := openDaemonLog(segments, int64(request.Offset))
 	if err != nil {
-		return errors.New("无权限访问日志文件")
+		return err
 	}
 	defer func() {
 		_ = f.Close()
 	}()
 	response.Offset = request.Offset
-	_, _ = f.Seek(int64(request.Offset), 0)
 	reader := bufio.NewReader(f)
 	var reg *regexp.Regexp
 	if request.Keyword != "" {
@@ -377,6 +573,9 @@ func (ds *DaemonServe) Log(request proto.DaemonLogArgs, response *proto.DaemonLo
 			break
 		}
 		response.Offset += uint(len(line))
+		if request.Stream != "" && daemonLogStream(line) != request.Stream {
+			continue
+		}
 		if reg == nil || reg.Match(line) {
 			response.Content = append(response.Content, string(line))
 		}
@@ -32,6 +32,14 @@ func init() {
 }
 
 func Start() {
+	if err := mrpc.SetTLS(config.RpcTLS()); err != nil {
+		log.Fatalf("Rpc TLS config failed, %s", err.Error())
+	}
+	mrpc.SetSecret(config.RpcSecret())
+	// 管理端以长连接声明的节点地址识别节点，没有密钥或双向证书时任何人都可以冒充
+	if config.ManageSession() && config.RpcSecret() == "" && !mrpc.MutualTLS() {
+		log.Fatalf("MANAGE_SESSION requires RPC_AUTH SECRET or RPC_TLS with CA_FILE")
+	}
 	migrate()
 	heartBeat()
 	c := newCrontab()
@@ -39,22 +47,27 @@ func Start() {
 	d := newDaemon()
 	d.start()
 	go handleSignal(c, d)
+	if config.ManageSession() {
+		go mrpc.Connect(config.ManageListenAddr(), config.NodeAddr())
+	}
 	mrpc.ListenAndServer(config.RpcListenAddr(), newServe(), newCrontabServe(c), newDaemonServe(d))
 }
 
 func migrate() {
-	err := model.Task().AutoMigrate(&model.Crontab{}, &model.CrontabLog{}, &model.Daemon{})
+	err := model.Task().AutoMigrate(&model.Crontab{}, &model.CrontabLog{}, &model.Daemon{}, &model.DaemonInstance{}, &model.DaemonLog{})
 	if err != nil {
 		log.Fatalf("Auto Migrate Failed")
 	}
 }
 
 func handleSignal(c *crontab, d *daemon) {
-	s := make(chan os.Signal)
+	s := make(chan os.Signal, 1)
 	signal.Notify(s, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	<-s
-	c.killAll()
-	d.delAllJob()
+	sig := <-s
+	Zap.Sugar().Infof("Received signal %s, draining running jobs\n", sig)
+	c.shutdown(time.Duration(config.DrainTimeout()) * time.Second)
+	d.shutdown(time.Duration(config.DaemonDrainTimeout()) * time.Second)
+	os.Exit(0)
 }
 
 func heartBeat() {
@@ -91,7 +104,7 @@ func heartBeat() {
 			nodeSync.Node.DaemonNum += 1
 			if v.Status == model.StatusUnaudited {
 				nodeSync.Node.AuditDaemonNum += 1
-			} else if v.Failed == 1 {
+			} else if v.Failed == 1 || v.Status == model.StatusCrashLoop {
 				nodeSync.Node.FailDaemonNum += 1
 			}
 		}
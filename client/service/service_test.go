@@ -0,0 +1,22 @@
+package service
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+// TestMain 读取包目录下的client.ini，使用内存数据库并创建表结构，运行时生成的日志等文件写入临时目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "task-client")
+	if err != nil {
+		log.Fatalf("Create temp dir failed, %s", err.Error())
+	}
+	if err = os.Chdir(dir); err != nil {
+		log.Fatalf("Change dir failed, %s", err.Error())
+	}
+	migrate()
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
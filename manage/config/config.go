@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"task/pkg/mrpc"
 )
 
 var config *ini.File
@@ -47,6 +48,27 @@ func RpcListenAddr() string {
 	return GetSection("APP").Key("RPC_LISTEN_ADDR").String()
 }
 
+// RpcTLS 节点与管理端之间的rpc传输加密配置，配置CA后双向校验证书
+func RpcTLS() mrpc.TLSOptions {
+	s := GetSection("RPC_TLS")
+	return mrpc.TLSOptions{
+		Enable:     s.Key("ENABLE").MustBool(false),
+		CaFile:     s.Key("CA_FILE").String(),
+		CertFile:   s.Key("CERT_FILE").String(),
+		KeyFile:    s.Key("KEY_FILE").String(),
+		ServerName: s.Key("SERVER_NAME").String(),
+	}
+}
+
+// RpcSecret 节点与管理端之间rpc调用签名使用的共享密钥，为空时不校验
+func RpcSecret() string {
+	return GetSection("RPC_AUTH").Key("SECRET").String()
+}
+
 func HttpListenAddr() string {
 	return GetSection("APP").Key("HTTP_LISTEN_ADDR").String()
 }
+
+func SecretKey() string {
+	return GetSection("APP").Key("SECRET_KEY").String()
+}
@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"task/model"
 	"task/pkg/mrpc"
 	"task/pkg/proto"
@@ -101,6 +105,10 @@ func (n *cron) addCrontab(ctx *gin.Context) {
 		failed(ctx, 1000, "Forbidden Access!!")
 		return
 	}
+	if err = secretService.checkRefs(user, addArgs.Crontab.Env); err != nil {
+		failed(ctx, 3054, err.Error())
+		return
+	}
 	addArgs.UserID = user.ID
 	reply := model.Crontab{}
 	err = mrpc.Call(fn.Address, "CrontabServe.Add", context.TODO(), addArgs, &reply)
@@ -108,6 +116,7 @@ func (n *cron) addCrontab(ctx *gin.Context) {
 		failed(ctx, 3006, "添加失败")
 		return
 	}
+	secretService.bindRefs(fn.ID, model.ObjectCrontab, reply.ID, reply.Env)
 	msg := fmt.Sprintf(model.ContentCrontabAdd, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, reply.Name)
 	model.Task().Create(&model.NodeLog{
 		UserID:     user.ID,
@@ -165,12 +174,17 @@ func (n *cron) editCrontab(ctx *gin.Context) {
 		failed(ctx, 1000, "Forbidden Access!!")
 		return
 	}
+	if err = secretService.checkRefs(user, editArgs.Crontab.Env); err != nil {
+		failed(ctx, 3055, err.Error())
+		return
+	}
 	editArgs.UserID = user.ID
 	err = mrpc.Call(fn.Address, "CrontabServe.Edit", context.TODO(), editArgs, &reply)
 	if err != nil {
 		failed(ctx, 3014, "修改失败")
 		return
 	}
+	secretService.bindRefs(fn.ID, model.ObjectCrontab, reply.ID, reply.Env)
 	msg := fmt.Sprintf(model.ContentCrontabEdit, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, reply.Name)
 	model.Task().Create(&model.NodeLog{
 		UserID:     user.ID,
@@ -394,8 +408,13 @@ func (n *cron) killCrontab(ctx *gin.Context) {
 		return
 	}
 	var msg string
+	// 终止单次执行时节点只返回执行所属的任务
 	for _, i := range reply {
-		msg = fmt.Sprintf(model.ContentCrontabKill, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name)
+		if startArgs.RunID != "" {
+			msg = fmt.Sprintf(model.ContentCrontabKillRun, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name, startArgs.RunID)
+		} else {
+			msg = fmt.Sprintf(model.ContentCrontabKill, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name)
+		}
 		model.Task().Create(&model.NodeLog{
 			UserID:     user.ID,
 			Action:     model.ActionKill,
@@ -440,6 +459,7 @@ func (n *cron) delCrontab(ctx *gin.Context) {
 	}
 	var msg string
 	for _, i := range reply {
+		secretService.unbindRefs(fn.ID, model.ObjectCrontab, i.ID)
 		msg = fmt.Sprintf(model.ContentCrontabDel, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name)
 		model.Task().Create(&model.NodeLog{
 			UserID:     user.ID,
@@ -460,9 +480,55 @@ type crontabLogListReply struct {
 	List  []*crontabLogListReplyItem `json:"list"`
 }
 
+type crontabRunListItem struct {
+	RunID     string `json:"run_id"`
+	CrontabID uint   `json:"crontab_id"`
+	Name      string `json:"name"`
+	Pid       int    `json:"pid"`
+	StartTime uint   `json:"start_time"`
+	Trigger   string `json:"trigger"`
+	ExecUser  string `json:"exec_user"`
+}
+
+func (n *cron) runList(ctx *gin.Context) {
+	var listArgs proto.CrontabRunListArgs
+	if err := ctx.ShouldBindJSON(&listArgs); err != nil {
+		failed(ctx, 3045, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", listArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 3046, "节点不存在")
+		return
+	}
+	users := rbacService.getUsers(ctx)
+	reply := make([]*proto.CrontabRun, 0)
+	err = mrpc.Call(fn.Address, "CrontabServe.Running", context.TODO(), listArgs, &reply)
+	if err != nil {
+		failed(ctx, 3047, "查询失败")
+		return
+	}
+	l := make([]*crontabRunListItem, 0, len(reply))
+	for _, i := range reply {
+		l = append(l, &crontabRunListItem{
+			RunID:     i.RunID,
+			CrontabID: i.CrontabID,
+			Name:      i.Name,
+			Pid:       i.Pid,
+			StartTime: i.StartTime,
+			Trigger:   i.Trigger,
+			ExecUser:  rbacService.getUserName(&users, i.UserID),
+		})
+	}
+	success(ctx, "查询成功", l)
+}
+
 type crontabLogListReplyItem struct {
 	ID        uint    `json:"id"`
+	RunID     string  `json:"run_id"`
 	Once      uint    `json:"once"`
+	Trigger   string  `json:"trigger"`
 	StartTime uint    `json:"start_time"`
 	EndTime   uint    `json:"end_time"`
 	CostTime  float64 `json:"cost_time"`
@@ -502,7 +568,9 @@ func (n *cron) log(ctx *gin.Context) {
 		for _, i := range reply.List {
 			r.List = append(r.List, &crontabLogListReplyItem{
 				ID:        i.ID,
+				RunID:     i.RunID,
 				Once:      i.Once,
+				Trigger:   i.Trigger,
 				StartTime: i.StartTime,
 				EndTime:   i.EndTime,
 				CostTime:  i.CostTime,
@@ -535,3 +603,64 @@ func (n *cron) clean(ctx *gin.Context) {
 	}
 	success(ctx, "清理完毕", reply)
 }
+
+func (n *cron) artifactList(ctx *gin.Context) {
+	var listArgs proto.CrontabArtifactArgs
+	if err := ctx.ShouldBindJSON(&listArgs); err != nil || listArgs.RunID == "" {
+		failed(ctx, 3048, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", listArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 3049, "节点不存在")
+		return
+	}
+	reply := make([]*proto.CrontabArtifact, 0)
+	err = mrpc.Call(fn.Address, "CrontabServe.Artifacts", context.TODO(), listArgs, &reply)
+	if err != nil {
+		failed(ctx, 3050, "查询失败")
+		return
+	}
+	success(ctx, "查询成功", reply)
+}
+
+func (n *cron) artifactDownload(ctx *gin.Context) {
+	var downloadArgs proto.CrontabArtifactArgs
+	if err := ctx.ShouldBindJSON(&downloadArgs); err != nil || downloadArgs.RunID == "" || downloadArgs.Name == "" {
+		failed(ctx, 3051, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", downloadArgs.NodeID).Error
+	if err != nil || fn.Status != model.NodeStatusOk {
+		failed(ctx, 3052, "节点不存在或不可用")
+		return
+	}
+	var chunk proto.CrontabArtifactChunk
+	err = mrpc.Call(fn.Address, "CrontabServe.Artifact", ctx.Request.Context(), downloadArgs, &chunk)
+	if err != nil {
+		failed(ctx, 3053, "文件不存在")
+		return
+	}
+	ctx.Header("Content-Type", "application/octet-stream")
+	ctx.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(path.Base(downloadArgs.Name)))
+	ctx.Header("Content-Length", strconv.FormatInt(chunk.Size, 10))
+	ctx.Status(http.StatusOK)
+	for {
+		if _, err = ctx.Writer.Write(chunk.Data); err != nil {
+			return
+		}
+		ctx.Writer.Flush()
+		if chunk.EOF || len(chunk.Data) == 0 {
+			return
+		}
+		downloadArgs.Offset += int64(len(chunk.Data))
+		chunk = proto.CrontabArtifactChunk{}
+		err = mrpc.Call(fn.Address, "CrontabServe.Artifact", ctx.Request.Context(), downloadArgs, &chunk)
+		if err != nil {
+			Zap.Sugar().Errorln("Artifact download interrupted, " + err.Error())
+			return
+		}
+	}
+}
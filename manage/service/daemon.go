@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"math"
+	"net/http"
+	"net/url"
+	"strconv"
 	"task/model"
 	"task/pkg/helper"
 	"task/pkg/mrpc"
@@ -22,14 +25,22 @@ type daemonListReply struct {
 }
 
 type daemonListReplyItem struct {
-	ID         uint   `json:"id"`
-	Name       string `json:"name"`
-	Command    string `json:"command"`
-	Dir        string `json:"dir"`
-	RunStatus  string `json:"run_status"`
-	Status     string `json:"status"`
-	CreateUser string `json:"create_user"`
-	CreateTime uint   `json:"create_time"`
+	ID              uint              `json:"id"`
+	Name            string            `json:"name"`
+	Command         string            `json:"command"`
+	Dir             string            `json:"dir"`
+	Numprocs        uint              `json:"numprocs"`
+	StartCron       string            `json:"start_cron"`
+	StopCron        string            `json:"stop_cron"`
+	RunStatus       string            `json:"run_status"`
+	Status          string            `json:"status"`
+	RetryNum        uint              `json:"retry_num"`
+	NextRestartTime uint              `json:"next_restart_time"`
+	HealthStatus    string            `json:"health_status"`
+	HealthMsg       string            `json:"health_msg"`
+	Usage           *proto.DaemonStat `json:"usage"`
+	CreateUser      string            `json:"create_user"`
+	CreateTime      uint              `json:"create_time"`
 }
 
 func (d *daemon) list(ctx *gin.Context) {
@@ -58,6 +69,7 @@ func (d *daemon) list(ctx *gin.Context) {
 		Total: reply.Total,
 		List:  make([]*daemonListReplyItem, 0),
 	}
+	usage := d.usage(fn.Address, reply.List)
 	if len(reply.List) > 0 {
 		var runStatus string
 		for _, i := range reply.List {
@@ -68,24 +80,80 @@ func (d *daemon) list(ctx *gin.Context) {
 			} else if i.Status == model.StatusRunning {
 				s := int64(math.Round(time.Now().Sub(time.Unix(int64(i.StartTime), 0)).Seconds()))
 				runStatus = fmt.Sprintf("正在运行(时长：%s)", helper.HumanTime(s))
+			} else if i.Status == model.StatusRestarting {
+				runStatus = fmt.Sprintf("等待重启(第%d次重试，重启时间：%s)", i.RetryNum, time.Unix(int64(i.NextRestartTime), 0).Format(proto.TimeLayout))
+			} else if i.Status == model.StatusWaiting {
+				runStatus = "等待依赖的任务就绪"
+			} else if i.Status == model.StatusCrashLoop {
+				if i.NextRestartTime > 0 {
+					runStatus = fmt.Sprintf("频繁崩溃(第%d次重试，重启时间：%s)", i.RetryNum, time.Unix(int64(i.NextRestartTime), 0).Format(proto.TimeLayout))
+				} else {
+					runStatus = "频繁崩溃(已重启，稳定运行后恢复)"
+				}
+			} else if i.Status == model.StatusStopping {
+				runStatus = "正在停止"
 			} else if i.Status == model.StatusStopped {
 				runStatus = fmt.Sprintf("已停止(原因：%s)", i.FailedReason)
 			}
 			r.List = append(r.List, &daemonListReplyItem{
-				ID:         i.ID,
-				Name:       i.Name,
-				Command:    i.Command,
-				Dir:        i.Dir,
-				RunStatus:  runStatus,
-				Status:     i.Status,
-				CreateUser: rbacService.getUserName(&users, i.CreateUserID),
-				CreateTime: i.CreateTime,
+				ID:              i.ID,
+				Name:            i.Name,
+				Command:         i.Command,
+				Dir:             i.Dir,
+				Numprocs:        i.Numprocs,
+				StartCron:       i.StartCron,
+				StopCron:        i.StopCron,
+				RunStatus:       runStatus,
+				Status:          i.Status,
+				RetryNum:        i.RetryNum,
+				NextRestartTime: i.NextRestartTime,
+				HealthStatus:    i.HealthStatus,
+				HealthMsg:       i.HealthMsg,
+				Usage:           usage[i.ID],
+				CreateUser:      rbacService.getUserName(&users, i.CreateUserID),
+				CreateTime:      i.CreateTime,
 			})
 		}
 	}
 	success(ctx, "查询成功", r)
 }
 
+// usage 汇总各实例最近一次的资源占用，查询失败时不影响列表展示
+func (d *daemon) usage(address string, list []*model.Daemon) map[uint]*proto.DaemonStat {
+	usage := make(map[uint]*proto.DaemonStat)
+	statArgs := proto.DaemonStatArgs{}
+	for _, i := range list {
+		if i.Status == model.StatusRunning || i.Status == model.StatusRestarting || i.Status == model.StatusWaiting || i.Status == model.StatusCrashLoop {
+			statArgs.DaemonIDS = append(statArgs.DaemonIDS, i.ID)
+		}
+	}
+	if len(statArgs.DaemonIDS) == 0 {
+		return usage
+	}
+	stats := make([]*proto.DaemonInstanceStat, 0)
+	err := mrpc.Call(address, "DaemonServe.Stat", context.TODO(), statArgs, &stats)
+	if err != nil {
+		return usage
+	}
+	for _, i := range stats {
+		if len(i.Samples) == 0 {
+			continue
+		}
+		last := i.Samples[len(i.Samples)-1]
+		u, ok := usage[i.DaemonID]
+		if !ok {
+			u = &proto.DaemonStat{Time: last.Time}
+			usage[i.DaemonID] = u
+		}
+		u.Procs += last.Procs
+		u.Rss += last.Rss
+		u.Cpu += last.Cpu
+		u.Fds += last.Fds
+		u.Threads += last.Threads
+	}
+	return usage
+}
+
 func (d *daemon) addDaemon(ctx *gin.Context) {
 	var addArgs proto.DaemonArgs
 	if err := ctx.ShouldBindJSON(&addArgs); err != nil {
@@ -103,13 +171,18 @@ func (d *daemon) addDaemon(ctx *gin.Context) {
 		failed(ctx, 1000, "Forbidden Access!!")
 		return
 	}
+	if err = secretService.checkRefs(user, addArgs.Daemon.Env); err != nil {
+		failed(ctx, 4053, err.Error())
+		return
+	}
 	addArgs.UserID = user.ID
 	reply := model.Daemon{}
 	err = mrpc.Call(fn.Address, "DaemonServe.Add", context.TODO(), addArgs, &reply)
 	if err != nil {
-		failed(ctx, 4006, "添加失败")
+		failed(ctx, 4006, "添加失败,"+err.Error())
 		return
 	}
+	secretService.bindRefs(fn.ID, model.ObjectDaemon, reply.ID, reply.Env)
 	msg := fmt.Sprintf(model.ContentDaemonAdd, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, reply.Name)
 	model.Task().Create(&model.NodeLog{
 		UserID:     user.ID,
@@ -167,12 +240,17 @@ func (d *daemon) editDaemon(ctx *gin.Context) {
 		failed(ctx, 1000, "Forbidden Access!!")
 		return
 	}
+	if err = secretService.checkRefs(user, editArgs.Daemon.Env); err != nil {
+		failed(ctx, 4054, err.Error())
+		return
+	}
 	editArgs.UserID = user.ID
 	err = mrpc.Call(fn.Address, "DaemonServe.Edit", context.TODO(), editArgs, &reply)
 	if err != nil {
-		failed(ctx, 4013, "修改失败")
+		failed(ctx, 4013, "修改失败,"+err.Error())
 		return
 	}
+	secretService.bindRefs(fn.ID, model.ObjectDaemon, reply.ID, reply.Env)
 	msg := fmt.Sprintf(model.ContentDaemonEdit, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, reply.Name)
 	model.Task().Create(&model.NodeLog{
 		UserID:     user.ID,
@@ -257,7 +335,7 @@ func (d *daemon) startDaemon(ctx *gin.Context) {
 	startArgs.UserID = user.ID
 	err = mrpc.Call(fn.Address, "DaemonServe.Start", context.TODO(), startArgs, &reply)
 	if err != nil {
-		failed(ctx, 4022, "操作失败")
+		failed(ctx, 4022, "操作失败,"+err.Error())
 		return
 	}
 	var msg string
@@ -322,6 +400,58 @@ func (d *daemon) stopDaemon(ctx *gin.Context) {
 	success(ctx, "操作成功", reply)
 }
 
+func (d *daemon) reloadDaemon(ctx *gin.Context) {
+	var reloadArgs proto.DaemonActionArgs
+	if err := ctx.ShouldBindJSON(&reloadArgs); err != nil {
+		failed(ctx, 4046, "请求参数不合法")
+		return
+	}
+	if len(reloadArgs.DaemonIDS) == 0 {
+		failed(ctx, 4047, "还未选择常驻任务")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", reloadArgs.NodeID).Error
+	if err != nil || fn.Status != model.NodeStatusOk {
+		failed(ctx, 4048, "节点不存在或不可用")
+		return
+	}
+	reply := make([]*proto.DaemonReloadResult, 0)
+	user := rbacService.currentUserInfo(ctx)
+	if user == nil {
+		failed(ctx, 1000, "Forbidden Access!!")
+		return
+	}
+	reloadArgs.UserID = user.ID
+	err = mrpc.Call(fn.Address, "DaemonServe.Reload", context.TODO(), reloadArgs, &reply)
+	if err != nil {
+		failed(ctx, 4049, "操作失败,"+err.Error())
+		return
+	}
+	var msg string
+	for _, i := range reply {
+		result := "成功"
+		if !i.Success {
+			result = "失败"
+		}
+		if i.Message != "" {
+			result += "," + i.Message
+		}
+		msg = fmt.Sprintf(model.ContentDaemonReload, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name, i.Instance, result)
+		model.Task().Create(&model.NodeLog{
+			UserID:     user.ID,
+			Action:     model.ActionReload,
+			Object:     model.ObjectDaemon,
+			ObjectID:   i.DaemonID,
+			NodeID:     fn.ID,
+			Content:    msg,
+			CreateTime: uint(time.Now().Unix()),
+		})
+		WSCManage.pushWSMessage(rbacService.getNodeRoleIDS(fn.ID), msg)
+	}
+	success(ctx, "操作成功", reply)
+}
+
 func (d *daemon) delDaemon(ctx *gin.Context) {
 	var delArgs proto.DaemonActionArgs
 	if err := ctx.ShouldBindJSON(&delArgs); err != nil {
@@ -352,6 +482,7 @@ func (d *daemon) delDaemon(ctx *gin.Context) {
 	}
 	var msg string
 	for _, i := range reply {
+		secretService.unbindRefs(fn.ID, model.ObjectDaemon, i.ID)
 		msg = fmt.Sprintf(model.ContentDaemonDel, time.Now().Format(proto.TimeLayout), user.RealName, fn.Address, i.Name)
 		model.Task().Create(&model.NodeLog{
 			UserID:     user.ID,
@@ -387,3 +518,179 @@ func (d *daemon) log(ctx *gin.Context) {
 	}
 	success(ctx, "查询成功", reply)
 }
+
+func (d *daemon) instanceList(ctx *gin.Context) {
+	var getArgs proto.DaemonGetArgs
+	if err := ctx.ShouldBindJSON(&getArgs); err != nil {
+		failed(ctx, 4034, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", getArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 4035, "节点不存在")
+		return
+	}
+	reply := make([]*model.DaemonInstance, 0)
+	err = mrpc.Call(fn.Address, "DaemonServe.Instances", context.TODO(), getArgs, &reply)
+	if err != nil {
+		failed(ctx, 4036, "查询失败")
+		return
+	}
+	success(ctx, "查询成功", reply)
+}
+
+type daemonHistoryReply struct {
+	Total int64                     `json:"total"`
+	List  []*daemonHistoryReplyItem `json:"list"`
+}
+
+type daemonHistoryReplyItem struct {
+	ID         uint    `json:"id"`
+	Instance   uint    `json:"instance"`
+	Event      string  `json:"event"`
+	Trigger    string  `json:"trigger"`
+	Pid        int     `json:"pid"`
+	ExitCode   int     `json:"exit_code"`
+	Signal     string  `json:"signal"`
+	Duration   float64 `json:"duration"`
+	Reason     string  `json:"reason"`
+	User       string  `json:"user"`
+	CreateTime uint    `json:"create_time"`
+}
+
+func (d *daemon) history(ctx *gin.Context) {
+	var listArgs proto.DaemonHistoryArgs
+	if err := ctx.ShouldBindJSON(&listArgs); err != nil {
+		failed(ctx, 4050, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", listArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 4051, "节点不存在")
+		return
+	}
+	users := rbacService.getUsers(ctx)
+	reply := proto.DaemonHistoryReply{
+		Total: 0,
+		List:  make([]*model.DaemonLog, 0),
+	}
+	err = mrpc.Call(fn.Address, "DaemonServe.History", context.TODO(), listArgs, &reply)
+	if err != nil {
+		failed(ctx, 4052, "查询失败")
+		return
+	}
+	r := &daemonHistoryReply{
+		Total: reply.Total,
+		List:  make([]*daemonHistoryReplyItem, 0),
+	}
+	for _, i := range reply.List {
+		r.List = append(r.List, &daemonHistoryReplyItem{
+			ID:         i.ID,
+			Instance:   i.Instance,
+			Event:      i.Event,
+			Trigger:    i.Trigger,
+			Pid:        i.Pid,
+			ExitCode:   i.ExitCode,
+			Signal:     i.Signal,
+			Duration:   i.Duration,
+			Reason:     i.Reason,
+			User:       rbacService.getUserName(&users, i.UserID),
+			CreateTime: i.CreateTime,
+		})
+	}
+	success(ctx, "查询成功", r)
+}
+
+func (d *daemon) stat(ctx *gin.Context) {
+	var statArgs proto.DaemonStatArgs
+	if err := ctx.ShouldBindJSON(&statArgs); err != nil {
+		failed(ctx, 4037, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", statArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 4038, "节点不存在")
+		return
+	}
+	reply := make([]*proto.DaemonInstanceStat, 0)
+	err = mrpc.Call(fn.Address, "DaemonServe.Stat", context.TODO(), statArgs, &reply)
+	if err != nil {
+		failed(ctx, 4039, "查询失败")
+		return
+	}
+	success(ctx, "查询成功", reply)
+}
+
+func (d *daemon) logSearch(ctx *gin.Context) {
+	var searchArgs proto.DaemonLogSearchArgs
+	if err := ctx.ShouldBindJSON(&searchArgs); err != nil {
+		failed(ctx, 4040, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", searchArgs.NodeID).Error
+	if err != nil {
+		failed(ctx, 4041, "节点不存在")
+		return
+	}
+	var reply proto.DaemonLogSearchReply
+	err = mrpc.Call(fn.Address, "DaemonServe.LogSearch", context.TODO(), searchArgs, &reply)
+	if err != nil {
+		failed(ctx, 4042, "查询失败,"+err.Error())
+		return
+	}
+	success(ctx, "查询成功", reply)
+}
+
+// logDownload 分块读取节点上的日志并流式返回，下载过程中新写入的内容不包含在内
+func (d *daemon) logDownload(ctx *gin.Context) {
+	var downloadArgs proto.DaemonLogArgs
+	if err := ctx.ShouldBindJSON(&downloadArgs); err != nil || downloadArgs.Date == "" {
+		failed(ctx, 4043, "请求参数不合法")
+		return
+	}
+	var fn model.Node
+	err := model.Task().First(&fn, "id=?", downloadArgs.NodeID).Error
+	if err != nil || fn.Status != model.NodeStatusOk {
+		failed(ctx, 4044, "节点不存在或不可用")
+		return
+	}
+	downloadArgs.Offset = 0
+	var chunk proto.DaemonLogChunk
+	err = mrpc.Call(fn.Address, "DaemonServe.LogChunk", ctx.Request.Context(), downloadArgs, &chunk)
+	if err != nil {
+		failed(ctx, 4045, "日志不存在")
+		return
+	}
+	size := chunk.Size
+	name := fmt.Sprintf("daemon_%d_%d_%s.log", downloadArgs.DaemonID, downloadArgs.Instance, downloadArgs.Date)
+	ctx.Header("Content-Type", "application/octet-stream")
+	ctx.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name))
+	ctx.Header("Content-Length", strconv.FormatInt(size, 10))
+	ctx.Status(http.StatusOK)
+	var written int64
+	for {
+		data := chunk.Data
+		if written+int64(len(data)) > size {
+			data = data[:size-written]
+		}
+		if _, err = ctx.Writer.Write(data); err != nil {
+			return
+		}
+		ctx.Writer.Flush()
+		written += int64(len(data))
+		if written >= size || chunk.EOF || len(chunk.Data) == 0 {
+			return
+		}
+		downloadArgs.Offset = uint(written)
+		chunk = proto.DaemonLogChunk{}
+		err = mrpc.Call(fn.Address, "DaemonServe.LogChunk", ctx.Request.Context(), downloadArgs, &chunk)
+		if err != nil {
+			Zap.Sugar().Errorln("Daemon log download interrupted, " + err.Error())
+			return
+		}
+	}
+}
@@ -13,6 +13,7 @@ func setRoute(e *gin.Engine) {
 	setCrontabRoute(e)
 	setDaemonRoute(e)
 	setConfigRoute(e)
+	setSecretRoute(e)
 }
 
 func setRbacRoute(e *gin.Engine) {
@@ -62,9 +63,12 @@ func setCrontabRoute(e *gin.Engine) {
 		POST("/stop", cronService.stopCrontab).
 		POST("/exec", cronService.execCrontab).
 		POST("/kill", cronService.killCrontab).
+		POST("/run/list", cronService.runList).
 		POST("/del", cronService.delCrontab).
 		POST("/log/list", cronService.log).
-		POST("/log/clean", cronService.clean)
+		POST("/log/clean", cronService.clean).
+		POST("/artifact/list", cronService.artifactList).
+		POST("/artifact/download", cronService.artifactDownload)
 }
 
 func setDaemonRoute(e *gin.Engine) {
@@ -76,8 +80,14 @@ func setDaemonRoute(e *gin.Engine) {
 		POST("/audit", daemonService.auditDaemon).
 		POST("/start", daemonService.startDaemon).
 		POST("/stop", daemonService.stopDaemon).
+		POST("/reload", daemonService.reloadDaemon).
 		POST("/del", daemonService.delDaemon).
-		POST("/log/list", daemonService.log)
+		POST("/instance/list", daemonService.instanceList).
+		POST("/history/list", daemonService.history).
+		POST("/stat", daemonService.stat).
+		POST("/log/list", daemonService.log).
+		POST("/log/search", daemonService.logSearch).
+		POST("/log/download", daemonService.logDownload)
 }
 
 func setConfigRoute(e *gin.Engine) {
@@ -87,3 +97,11 @@ func setConfigRoute(e *gin.Engine) {
 		POST("/edit", configService.edit).
 		POST("/del", configService.del)
 }
+
+func setSecretRoute(e *gin.Engine) {
+	e.Group("/secret", request(), maskParams(), auth()).
+		POST("/list", secretService.list).
+		POST("/add", secretService.add).
+		POST("/edit", secretService.edit).
+		POST("/del", secretService.del)
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-json"
+	"regexp"
+	"task/manage/config"
+	"task/model"
+	"task/pkg/cas"
+	"task/pkg/helper"
+	"task/pkg/proto"
+	"time"
+)
+
+var secretService *secret
+
+var secretNameReg = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+type secret struct{}
+
+type secretList struct {
+	Total int64           `json:"total"`
+	List  []*model.Secret `json:"list"`
+}
+
+func (s *secret) list(ctx *gin.Context) {
+	var listArgs proto.SecretListArgs
+	if err := ctx.ShouldBindJSON(&listArgs); err != nil {
+		failed(ctx, 6000, "请求参数不合法")
+		return
+	}
+	m := model.Task().Model(&model.Secret{})
+	if listArgs.Name != "" {
+		m.Where("name like ?", "%"+listArgs.Name+"%")
+	}
+	l := &secretList{
+		Total: 0,
+		List:  make([]*model.Secret, 0),
+	}
+	m.Count(&l.Total)
+	m.Order("create_time desc").Offset((listArgs.Page - 1) * listArgs.PageSize).Limit(listArgs.PageSize).Find(&l.List)
+	for _, i := range l.List {
+		i.Value = proto.SecretMask
+	}
+	success(ctx, "查询成功", l)
+}
+
+func (s *secret) add(ctx *gin.Context) {
+	var addArgs proto.SecretAddArgs
+	if err := ctx.ShouldBindJSON(&addArgs); err != nil {
+		failed(ctx, 6001, "请求参数不合法")
+		return
+	}
+	if config.SecretKey() == "" {
+		failed(ctx, 6012, "管理端未配置SECRET_KEY，无法保存密钥")
+		return
+	}
+	if !secretNameReg.MatchString(addArgs.Name) {
+		failed(ctx, 6002, "名称只允许字母、数字、下划线、点和中划线")
+		return
+	}
+	user := rbacService.currentUserInfo(ctx)
+	if user == nil {
+		failed(ctx, 1000, "Forbidden Access!!")
+		return
+	}
+	var c model.Secret
+	err := model.Task().First(&c, "name=?", addArgs.Name).Error
+	if err == nil {
+		failed(ctx, 6003, "名称已存在")
+		return
+	}
+	value, err := helper.AesEncrypt(config.SecretKey(), addArgs.Value)
+	if err != nil {
+		failed(ctx, 6004, "加密失败")
+		return
+	}
+	now := uint(time.Now().Unix())
+	c = model.Secret{
+		Name:         addArgs.Name,
+		Value:        value,
+		Desc:         addArgs.Desc,
+		RoleIDS:      addArgs.RoleIDS,
+		CreateUserID: user.ID,
+		UpdateUserID: user.ID,
+		CreateTime:   now,
+		UpdateTime:   now,
+	}
+	err = model.Task().Create(&c).Error
+	if err != nil {
+		failed(ctx, 6005, "添加失败")
+		return
+	}
+	success(ctx, "添加成功", c.ID)
+}
+
+func (s *secret) edit(ctx *gin.Context) {
+	var editArgs proto.SecretEditArgs
+	if err := ctx.ShouldBindJSON(&editArgs); err != nil {
+		failed(ctx, 6006, "请求参数不合法")
+		return
+	}
+	if config.SecretKey() == "" {
+		failed(ctx, 6013, "管理端未配置SECRET_KEY，无法保存密钥")
+		return
+	}
+	if !secretNameReg.MatchString(editArgs.Name) {
+		failed(ctx, 6007, "名称只允许字母、数字、下划线、点和中划线")
+		return
+	}
+	user := rbacService.currentUserInfo(ctx)
+	if user == nil {
+		failed(ctx, 1000, "Forbidden Access!!")
+		return
+	}
+	var c model.Secret
+	err := model.Task().First(&c, "id=?", editArgs.ID).Error
+	if err != nil {
+		failed(ctx, 6008, "数据不存在")
+		return
+	}
+	if editArgs.Value != "" && editArgs.Value != proto.SecretMask {
+		c.Value, err = helper.AesEncrypt(config.SecretKey(), editArgs.Value)
+		if err != nil {
+			failed(ctx, 6009, "加密失败")
+			return
+		}
+	}
+	c.Name = editArgs.Name
+	c.Desc = editArgs.Desc
+	c.RoleIDS = editArgs.RoleIDS
+	c.UpdateUserID = user.ID
+	c.UpdateTime = uint(time.Now().Unix())
+	err = model.Task().Save(&c).Error
+	if err != nil {
+		failed(ctx, 6010, "修改失败")
+		return
+	}
+	success(ctx, "修改成功", c.ID)
+}
+
+func (s *secret) del(ctx *gin.Context) {
+	var delArgs proto.IdArgs
+	if err := ctx.ShouldBindJSON(&delArgs); err != nil {
+		failed(ctx, 6011, "请求参数不合法")
+		return
+	}
+	model.Task().Delete(&model.Secret{}, delArgs.ID)
+	success(ctx, "删除成功", nil)
+}
+
+// maskParams 替换请求日志中记录的明文值，需要在鉴权等可能提前结束请求的中间件之前执行
+func maskParams() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		params := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(ctx.GetString("params")), &params); err != nil {
+			ctx.Set("params", "")
+			ctx.Next()
+			return
+		}
+		if _, ok := params["value"]; ok {
+			params["value"] = proto.SecretMask
+		}
+		b, _ := json.Marshal(params)
+		ctx.Set("params", string(b))
+		ctx.Next()
+	}
+}
+
+// checkRefs 检查用户能否引用环境变量中的密钥，管理员和密钥创建人可以引用，其他用户需要所属角色在密钥允许的角色中
+func (s *secret) checkRefs(user *cas.User, env []string) error {
+	names := proto.SecretRefNames(env)
+	if len(names) == 0 {
+		return nil
+	}
+	var secrets []*model.Secret
+	err := model.Task().Where("name in (?)", names).Find(&secrets).Error
+	if err != nil {
+		return err
+	}
+	found := make(map[string]*model.Secret, len(secrets))
+	for _, i := range secrets {
+		found[i.Name] = i
+	}
+	admin := user.IsAdmin == 1 || rbacService.isAdmin(user.ID)
+	roleID := rbacService.currentUserRoleId(user)
+	for _, name := range names {
+		c, ok := found[name]
+		if !ok {
+			return fmt.Errorf("密钥%s不存在", name)
+		}
+		if admin || c.CreateUserID == user.ID {
+			continue
+		}
+		allowed := false
+		for _, ID := range c.RoleIDS {
+			if roleID != 0 && ID == roleID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("无权引用密钥%s", name)
+		}
+	}
+	return nil
+}
+
+// bindRefs 记录任务当前引用的密钥，替换该任务之前的记录
+func (s *secret) bindRefs(nodeID uint, object string, objectID uint, env []string) {
+	model.Task().Where("node_id=? and object=? and object_id=?", nodeID, object, objectID).Delete(&model.SecretRef{})
+	seen := make(map[string]bool)
+	for _, name := range proto.SecretRefNames(env) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		model.Task().Create(&model.SecretRef{NodeID: nodeID, Object: object, ObjectID: objectID, Name: name})
+	}
+}
+
+func (s *secret) unbindRefs(nodeID uint, object string, objectID uint) {
+	model.Task().Where("node_id=? and object=? and object_id=?", nodeID, object, objectID).Delete(&model.SecretRef{})
+}
+
+// refNames 节点上的任务引用的全部密钥名称
+func (s *secret) refNames(nodeID uint) map[string]bool {
+	var names []string
+	model.Task().Model(&model.SecretRef{}).Where("node_id=?", nodeID).Distinct().Pluck("name", &names)
+	refs := make(map[string]bool, len(names))
+	for _, name := range names {
+		refs[name] = true
+	}
+	return refs
+}
+
+func (s *secret) resolve(names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return values, nil
+	}
+	var secrets []*model.Secret
+	err := model.Task().Where("name in (?)", names).Find(&secrets).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range secrets {
+		values[i.Name], err = helper.AesDecrypt(config.SecretKey(), i.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
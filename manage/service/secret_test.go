@@ -0,0 +1,122 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"task/model"
+	"task/pkg/cas"
+	"task/pkg/proto"
+	"testing"
+)
+
+func TestMaskParams(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := Zap
+	Zap = zap.New(core)
+	t.Cleanup(func() {
+		Zap = l
+		model.Task().Where("1=1").Delete(&model.Secret{})
+	})
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	setSecretRoute(e)
+	// 绕过鉴权，覆盖保存成功时的日志
+	e.POST("/test/secret/add", request(), maskParams(), func(ctx *gin.Context) {
+		ctx.Set("user", &cas.User{ID: 1, IsAdmin: 1})
+	}, secretService.add)
+	const value = "plain-secret-value"
+	cases := []struct {
+		name       string
+		url        string
+		body       string
+		wantParams string
+	}{
+		{"auth failed", "/secret/add", `{"name":"db","value":"` + value + `","desc":"x"}`, `"value":"` + proto.SecretMask + `"`},
+		{"edit auth failed", "/secret/edit", `{"id":1,"name":"db","value":"` + value + `"}`, `"value":"` + proto.SecretMask + `"`},
+		{"invalid body", "/secret/add", `{"name":"db","value":"` + value + `"`, ""},
+		{"value not string", "/secret/add", `{"name":"db","value":["` + value + `"]}`, `"value":"` + proto.SecretMask + `"`},
+		{"saved", "/test/secret/add", `{"name":"db","value":"` + value + `"}`, `"value":"` + proto.SecretMask + `"`},
+	}
+	for _, c := range cases {
+		logs.TakeAll()
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodPost, c.url, strings.NewReader(c.body)))
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("%s: got %d log entries, want 1", c.name, len(entries))
+		}
+		params, _ := entries[0].ContextMap()["params"].(string)
+		if strings.Contains(params, value) {
+			t.Fatalf("%s: plaintext logged in params %s", c.name, params)
+		}
+		if !strings.Contains(params, c.wantParams) {
+			t.Fatalf("%s: params %q should contain %q", c.name, params, c.wantParams)
+		}
+	}
+	var c model.Secret
+	if err := model.Task().First(&c, "name=?", "db").Error; err != nil || c.Value == value {
+		t.Fatalf("secret should be saved encrypted, got %q %v", c.Value, err)
+	}
+}
+
+func TestCheckRefs(t *testing.T) {
+	t.Cleanup(func() {
+		model.Task().Where("1=1").Delete(&model.Secret{})
+		model.Task().Where("1=1").Delete(&model.RbacRoleUser{})
+	})
+	secrets := []*model.Secret{
+		{Name: "private", CreateUserID: 10},
+		{Name: "shared", CreateUserID: 10, RoleIDS: model.UintSlice{3, 4}},
+	}
+	for _, c := range secrets {
+		if err := model.Task().Create(c).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	roleUsers := []*model.RbacRoleUser{
+		{UserID: 20, RoleID: proto.RoleAdmin},
+		{UserID: 30, RoleID: 3},
+		{UserID: 40, RoleID: 5},
+	}
+	for _, r := range roleUsers {
+		if err := model.Task().Create(r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref := func(names ...string) []string {
+		env := make([]string, 0, len(names))
+		for _, name := range names {
+			env = append(env, "V=${secret:"+name+"}")
+		}
+		return env
+	}
+	cases := []struct {
+		name    string
+		user    *cas.User
+		env     []string
+		wantErr string
+	}{
+		{"no refs", &cas.User{ID: 50}, []string{"A=1"}, ""},
+		{"super admin", &cas.User{ID: 50, IsAdmin: 1}, ref("private", "shared"), ""},
+		{"admin role", &cas.User{ID: 20}, ref("private"), ""},
+		{"creator", &cas.User{ID: 10}, ref("private", "shared"), ""},
+		{"allowed role", &cas.User{ID: 30}, ref("shared"), ""},
+		{"private to other role", &cas.User{ID: 30}, ref("shared", "private"), "无权引用密钥private"},
+		{"role not allowed", &cas.User{ID: 40}, ref("shared"), "无权引用密钥shared"},
+		{"no role", &cas.User{ID: 50}, ref("shared"), "无权引用密钥shared"},
+		{"not exist", &cas.User{ID: 10}, ref("missing"), "密钥missing不存在"},
+	}
+	for _, c := range cases {
+		err := secretService.checkRefs(c.user, c.env)
+		if c.wantErr == "" && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.wantErr != "" && (err == nil || err.Error() != c.wantErr) {
+			t.Fatalf("%s: got %v, want %s", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"net"
 	"net/http"
 	"strings"
+	"task/manage/config"
 	"task/model"
 	"task/pkg/proto"
 	"time"
@@ -98,3 +100,81 @@ func (s *Serve) DingTalkNotice(request proto.DingTalkNoticeArgs, response *bool)
 	}
 	return nil
 }
+
+// Secret 节点根据连接识别，只能换取自身任务引用的密钥
+func (s *Serve) Secret(request proto.SecretResolveArgs, response *map[string]string) error {
+	if config.SecretKey() == "" {
+		return errors.New("secret key not configured")
+	}
+	var n model.Node
+	err := model.Task().First(&n, "address=?", request.Address).Error
+	if err != nil || n.Status != model.NodeStatusOk {
+		return errors.New("node not available")
+	}
+	if !peerIsNode(request.Peer, n.Address) {
+		Zap.Sugar().Warnf("Secret resolve rejected, caller %s is not node %s", request.Peer.Addr, n.Address)
+		return errors.New("caller is not the node")
+	}
+	refs := secretService.refNames(n.ID)
+	for _, name := range request.Names {
+		if !refs[name] {
+			return errors.New("secret " + name + " not referenced by node")
+		}
+	}
+	values, err := secretService.resolve(request.Names)
+	if err != nil {
+		Zap.Sugar().Errorln("Secret resolve Failed, " + err.Error())
+		return errors.New("secret resolve failed")
+	}
+	for _, name := range request.Names {
+		if _, ok := values[name]; !ok {
+			return errors.New("secret " + name + " not exist")
+		}
+	}
+	*response = values
+	return nil
+}
+
+// knownNode 只接受已注册节点建立的长连接
+func knownNode(nodeAddr string) error {
+	var n model.Node
+	if err := model.Task().Take(&n, "address=?", nodeAddr).Error; err != nil {
+		return fmt.Errorf("unknown node %s", nodeAddr)
+	}
+	return nil
+}
+
+// peerIsNode 经由长连接调用时比较节点注册的地址，双向证书校验通过时比较证书中的域名和IP，否则比较连接的来源IP
+func peerIsNode(p proto.RpcPeer, address string) bool {
+	if p.Node != "" {
+		return p.Node == address
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if len(p.Names) > 0 {
+		for _, name := range p.Names {
+			if name == host {
+				return true
+			}
+		}
+		return false
+	}
+	if p.Addr == "" {
+		return false
+	}
+	if p.Addr == host {
+		return true
+	}
+	ips, err := net.LookupHost(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip == p.Addr {
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-redis/redis"
 	"go.uber.org/zap"
 	gormlogger "gorm.io/gorm/logger"
+	"log"
 	"net/http"
 	"task/manage/config"
 	"task/model"
@@ -31,10 +32,23 @@ func init() {
 }
 
 func Start() {
+	if err := mrpc.SetTLS(config.RpcTLS()); err != nil {
+		log.Fatalf("Rpc TLS config failed, %s", err.Error())
+	}
+	mrpc.SetSecret(config.RpcSecret())
+	mrpc.SetSessionValidator(knownNode)
+	migrate()
 	go rpcServe()
 	httpServe()
 }
 
+func migrate() {
+	err := model.Task().AutoMigrate(&model.Secret{}, &model.SecretRef{})
+	if err != nil {
+		log.Fatalf("Auto Migrate Failed")
+	}
+}
+
 func rpcServe() {
 	mrpc.ListenAndServer(config.RpcListenAddr(), NewServe())
 	Zap.Sugar().Errorln("Rpc Serve is stopped!!!")
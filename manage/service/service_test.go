@@ -0,0 +1,26 @@
+package service
+
+import (
+	"log"
+	"os"
+	"task/model"
+	"testing"
+)
+
+// TestMain 读取包目录下的manage.ini，使用内存数据库并创建表结构，运行时生成的日志写入临时目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "task-manage")
+	if err != nil {
+		log.Fatalf("Create temp dir failed, %s", err.Error())
+	}
+	if err = os.Chdir(dir); err != nil {
+		log.Fatalf("Change dir failed, %s", err.Error())
+	}
+	migrate()
+	if err = model.Task().AutoMigrate(&model.RbacRoleUser{}); err != nil {
+		log.Fatalf("Auto Migrate Failed")
+	}
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -13,12 +13,17 @@ const (
 	StatusRunning    string = "Running"
 	StatusStopped    string = "Stopped"
 	StatusRestarting string = "Restarting"
+	StatusWaiting    string = "Waiting"
+	StatusCrashLoop  string = "CrashLoop"
+	StatusStopping   string = "Stopping"
 )
 
 const (
-	ExecStatusError   string = "Error"
-	ExecStatusSuccess string = "Success"
-	ExecStatusTimeout string = "Timeout"
+	ExecStatusError         string = "Error"
+	ExecStatusSuccess       string = "Success"
+	ExecStatusTimeout       string = "Timeout"
+	ExecStatusInterrupted   string = "Interrupted"
+	ExecStatusPreHookFailed string = "PreHookFailed"
 )
 
 const (
@@ -30,9 +35,13 @@ type Crontab struct {
 	ID             uint        `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
 	Name           string      `json:"name" gorm:"size:100;commit:任务名"`
 	Command        string      `json:"command" gorm:"size:255;commit:执行命令"`
+	Template       uint        `json:"template" gorm:"comment:是否按模板渲染命令 0-否 1-是"`
 	User           string      `json:"user" gorm:"size:30;commit:执行用户"`
 	Env            StringSlice `json:"env" gorm:"type:varchar(255);commit:执行环境变量"`
 	Dir            string      `json:"dir" gorm:"size:256;commit:执行目录"`
+	PreHook        string      `json:"pre_hook" gorm:"size:255;comment:执行前钩子命令"`
+	PostHook       string      `json:"post_hook" gorm:"size:255;comment:执行后钩子命令"`
+	Artifacts      StringSlice `json:"artifacts" gorm:"type:varchar(1000);comment:归档文件规则，相对执行目录"`
 	Timeout        uint        `json:"timeout" gorm:"执行超时时间"`
 	LastExecStatus string      `json:"last_exec_status" gorm:"size:30;commit:上次执行状态"`
 	LastExecMsg    string      `json:"last_exec_msg" gorm:"type:varchar(1000);commit:上次执行信息"`
@@ -40,10 +49,13 @@ type Crontab struct {
 	LastExecTime   uint        `json:"last_exec_time" gorm:"commit:上次执行时间"`
 	NextExecTime   uint        `json:"next_exec_time" gorm:"commit:下次执行时间"`
 	TimeExpr       string      `json:"time_expr" gorm:"type:varchar(100);commit:cron表达式"`
+	RebootDelay    uint        `json:"reboot_delay" gorm:"comment:@reboot任务在客户端启动后延迟执行的秒数"`
 	Status         string      `json:"status" gorm:"size:30;commit:状态"`
 	TimeoutTrigger StringSlice `json:"timeout_trigger" gorm:"type:varchar(255);commit:超时触发方式"`
 	ErrorTrigger   StringSlice `json:"error_trigger" gorm:"type:varchar(255);commit:错误触发方式"`
 	DingTalkAddr   StringSlice `json:"ding_talk_addr"  gorm:"type:varchar(1000);commit:钉钉通知地址"`
+	LogMaxAge      *uint       `json:"log_max_age" gorm:"comment:日志保留天数 null-使用节点默认值 0-不清理"`
+	LogMaxRows     *uint       `json:"log_max_rows" gorm:"comment:日志保留条数 null-使用节点默认值 0-不清理"`
 	CreateUserID   uint        `json:"create_user_id" gorm:"commit:创建人ID"`
 	UpdateUserID   uint        `json:"update_user_id" gorm:"commit:更新人ID"`
 	CreateTime     uint        `json:"create_time" gorm:"comment:创建时间"`
@@ -82,3 +94,34 @@ func (s StringSlice) Value() (driver.Value, error) {
 	bts, err := json.Marshal(s)
 	return string(bts), err
 }
+
+type UintSlice []uint
+
+func (s *UintSlice) Scan(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		*s = make(UintSlice, 0)
+		return nil
+	case string:
+		return json.Unmarshal([]byte(val), s)
+	case []byte:
+		return json.Unmarshal(val, s)
+	default:
+		return errors.New("not support")
+	}
+}
+
+func (s UintSlice) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		s = make(UintSlice, 0)
+	}
+	return json.Marshal([]uint(s))
+}
+
+func (s UintSlice) Value() (driver.Value, error) {
+	if s == nil {
+		s = make(UintSlice, 0)
+	}
+	bts, err := json.Marshal([]uint(s))
+	return string(bts), err
+}
@@ -1,10 +1,21 @@
 package model
 
+const (
+	TriggerSchedule string = "schedule"
+	TriggerManual   string = "manual"
+	TriggerReboot   string = "reboot"
+)
+
+// TimeExprReboot 客户端每次启动时执行一次
+const TimeExprReboot string = "@reboot"
+
 type CrontabLog struct {
 	ID         uint    `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
 	CrontabID  uint    `json:"crontab_id" gorm:"定时任务ID"`
+	RunID      string  `json:"run_id" gorm:"index;size:64;comment:执行ID"`
 	Status     string  `json:"status" gorm:"size:30;commit:执行状态"`
 	Once       uint    `json:"once" gorm:"commit:是否为手动执行"`
+	Trigger    string  `json:"trigger" gorm:"size:30;comment:触发方式 schedule/manual/reboot"`
 	StartTime  uint    `json:"start_time" gorm:"commit:执行开始时间"`
 	EndTime    uint    `json:"end_time" gorm:"commit:执行结束时间"`
 	CostTime   float64 `json:"cost_time" gorm:"commit:耗时"`
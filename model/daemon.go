@@ -1,24 +1,80 @@
 package model
 
+const (
+	// RestartAlways 无论退出原因都重启，失败重启次数为0时不限制重启次数
+	RestartAlways    string = "always"
+	RestartOnFailure string = "on-failure"
+	RestartNever     string = "never"
+)
+
+const (
+	DependsRunning string = "running"
+	DependsHealthy string = "healthy"
+)
+
+const (
+	HealthCheckHttp string = "http"
+	HealthCheckTcp  string = "tcp"
+	HealthCheckExec string = "exec"
+
+	HealthUnknown   string = ""
+	HealthHealthy   string = "Healthy"
+	HealthUnhealthy string = "Unhealthy"
+)
+
 type Daemon struct {
-	ID               uint        `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
-	Name             string      `json:"name" gorm:"size:100;commit:任务名"`
-	Command          string      `json:"command" gorm:"size:255;commit:执行命令"`
-	User             string      `json:"user" gorm:"size:30;commit:执行用户"`
-	Env              StringSlice `json:"env" gorm:"type:varchar(255);commit:执行环境变量"`
-	Dir              string      `json:"dir" gorm:"size:256;commit:执行目录"`
-	StartTime        uint        `json:"start_time" gorm:"comment:开启时间"`
-	EndTime          uint        `json:"end_time" gorm:"comment:结束时间"`
-	FailedRestartNum uint        `json:"failed_restart_num" gorm:"commit:失败重启次数"`
-	Status           string      `json:"status" gorm:"size:30;commit:状态"`
-	Failed           uint        `json:"failed" gorm:"commit:是否执行失败 0-否 1-是"`
-	FailedReason     string      `json:"failed_msg" gorm:"commit:失败原因"`
-	FailedNotice     StringSlice `json:"failed_notice" gorm:"type:varchar(255);commit:失败通知方式"`
-	DingTalkAddr     StringSlice `json:"ding_talk_addr"  gorm:"type:varchar(1000);commit:钉钉通知地址"`
-	CreateUserID     uint        `json:"create_user_id" gorm:"commit:创建人ID"`
-	UpdateUserID     uint        `json:"update_user_id" gorm:"commit:更新人ID"`
-	CreateTime       uint        `json:"create_time" gorm:"comment:创建时间"`
-	UpdateTime       uint        `json:"update_time" gorm:"comment:更新时间"`
+	ID                uint        `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
+	Name              string      `json:"name" gorm:"size:100;commit:任务名"`
+	Command           string      `json:"command" gorm:"size:255;commit:执行命令"`
+	User              string      `json:"user" gorm:"size:30;commit:执行用户"`
+	Env               StringSlice `json:"env" gorm:"type:varchar(255);commit:执行环境变量"`
+	Dir               string      `json:"dir" gorm:"size:256;commit:执行目录"`
+	StartPriority     int         `json:"start_priority" gorm:"comment:启动优先级，越小越先启动"`
+	DependsOn         UintSlice   `json:"depends_on" gorm:"type:varchar(255);comment:依赖的常驻任务ID"`
+	DependsCondition  string      `json:"depends_condition" gorm:"size:30;comment:依赖就绪条件 running/healthy，默认running"`
+	DependsTimeout    uint        `json:"depends_timeout" gorm:"comment:等待依赖就绪的秒数，默认60"`
+	StartCron         string      `json:"start_cron" gorm:"size:100;comment:自动开启的时间表达式，空为不自动开启"`
+	StopCron          string      `json:"stop_cron" gorm:"size:100;comment:自动停止的时间表达式，空为不自动停止"`
+	StopSignal        string      `json:"stop_signal" gorm:"size:10;comment:停止信号 TERM/INT/QUIT/HUP/KILL/USR1/USR2，默认TERM"`
+	StopWait          uint        `json:"stop_wait" gorm:"comment:发送停止信号后等待退出的秒数，超时后强制结束，默认10"`
+	ReloadSignal      string      `json:"reload_signal" gorm:"size:10;comment:重载信号，默认HUP"`
+	ReloadCommand     string      `json:"reload_command" gorm:"size:255;comment:重载命令，配置后代替发送重载信号"`
+	PreHook           string      `json:"pre_hook" gorm:"size:255;comment:启动前钩子命令"`
+	PostHook          string      `json:"post_hook" gorm:"size:255;comment:退出后钩子命令"`
+	StartTime         uint        `json:"start_time" gorm:"comment:开启时间"`
+	EndTime           uint        `json:"end_time" gorm:"comment:结束时间"`
+	Numprocs          uint        `json:"numprocs" gorm:"comment:实例数量，0和1均为单实例"`
+	LogMaxSize        uint        `json:"log_max_size" gorm:"comment:单个日志文件大小上限(MB)，0为使用节点默认值"`
+	LogMaxAge         uint        `json:"log_max_age" gorm:"comment:日志保留天数，0为使用节点默认值"`
+	LogMaxTotal       uint        `json:"log_max_total" gorm:"comment:日志总大小上限(MB)，0为使用节点默认值"`
+	FailedRestartNum  uint        `json:"failed_restart_num" gorm:"commit:最大重启次数，always策略下0为不限制"`
+	RestartPolicy     string      `json:"restart_policy" gorm:"size:30;comment:重启策略 always/on-failure/never"`
+	RestartBackoff    uint        `json:"restart_backoff" gorm:"comment:首次重启间隔(秒)，之后指数递增"`
+	RestartMaxDelay   uint        `json:"restart_max_delay" gorm:"comment:最大重启间隔(秒)"`
+	RestartResetAfter uint        `json:"restart_reset_after" gorm:"comment:持续运行多少秒后重置重试次数"`
+	CrashLoopRestarts uint        `json:"crash_loop_restarts" gorm:"comment:窗口内重启多少次判定为频繁崩溃，0为默认5次"`
+	CrashLoopWindow   uint        `json:"crash_loop_window" gorm:"comment:统计重启次数的滑动窗口(秒)，0为默认300秒"`
+	CrashLoopBackoff  uint        `json:"crash_loop_backoff" gorm:"comment:频繁崩溃时的重启间隔(秒)，0为默认300秒"`
+	RetryNum          uint        `json:"retry_num" gorm:"comment:当前重试次数"`
+	NextRestartTime   uint        `json:"next_restart_time" gorm:"comment:下次重启时间"`
+	HealthCheck       string      `json:"health_check" gorm:"size:30;comment:健康检查方式 http/tcp/exec，空为不检查"`
+	HealthPort        uint        `json:"health_port" gorm:"comment:http/tcp检查的本地端口"`
+	HealthPath        string      `json:"health_path" gorm:"size:255;comment:http检查路径"`
+	HealthCommand     string      `json:"health_command" gorm:"size:255;comment:exec检查命令"`
+	HealthInterval    uint        `json:"health_interval" gorm:"comment:检查间隔(秒)"`
+	HealthTimeout     uint        `json:"health_timeout" gorm:"comment:检查超时(秒)"`
+	HealthThreshold   uint        `json:"health_threshold" gorm:"comment:连续失败多少次判定为不健康"`
+	HealthStatus      string      `json:"health_status" gorm:"size:30;comment:健康状态"`
+	HealthMsg         string      `json:"health_msg" gorm:"size:255;comment:最近一次检查失败原因"`
+	Status            string      `json:"status" gorm:"size:30;commit:状态"`
+	Failed            uint        `json:"failed" gorm:"commit:是否执行失败 0-否 1-是"`
+	FailedReason      string      `json:"failed_msg" gorm:"commit:失败原因"`
+	FailedNotice      StringSlice `json:"failed_notice" gorm:"type:varchar(255);commit:失败通知方式"`
+	DingTalkAddr      StringSlice `json:"ding_talk_addr"  gorm:"type:varchar(1000);commit:钉钉通知地址"`
+	CreateUserID      uint        `json:"create_user_id" gorm:"commit:创建人ID"`
+	UpdateUserID      uint        `json:"update_user_id" gorm:"commit:更新人ID"`
+	CreateTime        uint        `json:"create_time" gorm:"comment:创建时间"`
+	UpdateTime        uint        `json:"update_time" gorm:"comment:更新时间"`
 }
 
 func (Daemon) TableName() string {
@@ -0,0 +1,24 @@
+package model
+
+type DaemonInstance struct {
+	ID              uint   `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
+	DaemonID        uint   `json:"daemon_id" gorm:"index;comment:常驻任务ID"`
+	Instance        uint   `json:"instance" gorm:"comment:实例序号，从0开始"`
+	Status          string `json:"status" gorm:"size:30;comment:状态"`
+	StartTime       uint   `json:"start_time" gorm:"comment:开启时间"`
+	EndTime         uint   `json:"end_time" gorm:"comment:结束时间"`
+	RetryNum        uint   `json:"retry_num" gorm:"comment:当前重试次数"`
+	NextRestartTime uint   `json:"next_restart_time" gorm:"comment:下次重启时间"`
+	Failed          uint   `json:"failed" gorm:"comment:是否执行失败 0-否 1-是"`
+	FailedReason    string `json:"failed_msg" gorm:"comment:失败原因"`
+	HealthStatus    string `json:"health_status" gorm:"size:30;comment:健康状态"`
+	HealthMsg       string `json:"health_msg" gorm:"size:255;comment:最近一次检查失败原因"`
+	Pid             int    `json:"pid" gorm:"comment:进程ID"`
+	ProcStartTime   uint64 `json:"-" gorm:"comment:进程启动时间，与进程ID一起识别进程"`
+	StdoutOffset    int64  `json:"-" gorm:"comment:标准输出已读取的位置"`
+	StderrOffset    int64  `json:"-" gorm:"comment:标准错误已读取的位置"`
+}
+
+func (DaemonInstance) TableName() string {
+	return "t_daemon_instance"
+}
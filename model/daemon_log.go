@@ -0,0 +1,35 @@
+package model
+
+const (
+	DaemonEventStart   string = "start"
+	DaemonEventExit    string = "exit"
+	DaemonEventRestart string = "restart"
+	DaemonEventStop    string = "stop"
+	DaemonEventReload  string = "reload"
+)
+
+const (
+	DaemonTriggerUser       string = "user"
+	DaemonTriggerSupervisor string = "supervisor"
+	DaemonTriggerHealth     string = "health"
+	DaemonTriggerSchedule   string = "schedule"
+)
+
+type DaemonLog struct {
+	ID         uint    `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
+	DaemonID   uint    `json:"daemon_id" gorm:"index;comment:常驻任务ID"`
+	Instance   uint    `json:"instance" gorm:"comment:实例序号"`
+	Event      string  `json:"event" gorm:"size:30;comment:事件 start/exit/restart/stop/reload"`
+	Trigger    string  `json:"trigger" gorm:"size:30;comment:触发方 user/supervisor/health/schedule"`
+	Pid        int     `json:"pid" gorm:"comment:进程ID"`
+	ExitCode   int     `json:"exit_code" gorm:"comment:退出码，被信号结束或未知时为-1"`
+	Signal     string  `json:"signal" gorm:"size:30;comment:结束进程的信号"`
+	Duration   float64 `json:"duration" gorm:"comment:运行时长(秒)"`
+	Reason     string  `json:"reason" gorm:"type:varchar(1000);comment:原因"`
+	UserID     uint    `json:"user_id" gorm:"comment:操作人ID"`
+	CreateTime uint    `json:"create_time" gorm:"index;comment:创建时间"`
+}
+
+func (DaemonLog) TableName() string {
+	return "t_daemon_log"
+}
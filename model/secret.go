@@ -0,0 +1,30 @@
+package model
+
+type Secret struct {
+	ID           uint      `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
+	Name         string    `json:"name" gorm:"unique;size:64;comment:名称"`
+	Value        string    `json:"value" gorm:"type:varchar(2000);comment:加密后的值"`
+	Desc         string    `json:"desc" gorm:"size:255;comment:描述"`
+	RoleIDS      UintSlice `json:"role_ids" gorm:"type:varchar(255);comment:允许引用的角色ID，为空时只有创建人和管理员可以引用"`
+	CreateUserID uint      `json:"create_user_id" gorm:"comment:创建人ID"`
+	UpdateUserID uint      `json:"update_user_id" gorm:"comment:更新人ID"`
+	CreateTime   uint      `json:"create_time" gorm:"comment:创建时间"`
+	UpdateTime   uint      `json:"update_time" gorm:"comment:更新时间"`
+}
+
+func (Secret) TableName() string {
+	return "t_secret"
+}
+
+// SecretRef 节点上的任务引用的密钥，在管理端添加或修改任务时记录，节点只能换取自身任务引用的密钥
+type SecretRef struct {
+	ID       uint   `json:"id" gorm:"primaryKey;autoIncrement;comment:主键ID"`
+	NodeID   uint   `json:"node_id" gorm:"index;comment:节点ID"`
+	Object   string `json:"object" gorm:"size:30;comment:引用对象 Crontab/Daemon"`
+	ObjectID uint   `json:"object_id" gorm:"comment:引用对象ID"`
+	Name     string `json:"name" gorm:"size:64;comment:密钥名称"`
+}
+
+func (SecretRef) TableName() string {
+	return "t_secret_ref"
+}
@@ -0,0 +1,54 @@
+package crontab
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextExecTime(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	now := time.Date(2026, 10, 19, 10, 20, 30, 0, cst)
+	cases := []struct {
+		expr string
+		now  time.Time
+		want time.Time
+	}{
+		{"* * * * * ?", now, time.Date(2026, 10, 19, 10, 20, 31, 0, cst)},
+		{"0 * * * * ?", now, time.Date(2026, 10, 19, 10, 21, 0, 0, cst)},
+		{"*/15 * * * * ?", now, time.Date(2026, 10, 19, 10, 20, 45, 0, cst)},
+		{"0 0 * * * ?", now, time.Date(2026, 10, 19, 11, 0, 0, 0, cst)},
+		{"0 30 11 * * ?", now, time.Date(2026, 10, 19, 11, 30, 0, 0, cst)},
+		{"0 30 9 * * ?", now, time.Date(2026, 10, 20, 9, 30, 0, 0, cst)},
+		{"0 0 8-18 * * ?", now, time.Date(2026, 10, 19, 11, 0, 0, 0, cst)},
+		{"0 0 9,21 * * ?", now, time.Date(2026, 10, 19, 21, 0, 0, 0, cst)},
+		{"0 0 9 1 * ?", now, time.Date(2026, 11, 1, 9, 0, 0, 0, cst)},
+		{"0 0 0 29 2 ?", now, time.Date(2028, 2, 29, 0, 0, 0, 0, cst)},
+		{"0 0 0 * * ?", time.Date(2026, 12, 31, 23, 59, 59, 0, cst), time.Date(2027, 1, 1, 0, 0, 0, 0, cst)},
+	}
+	for _, c := range cases {
+		got, err := NewParse(c.expr).NextExecTime(c.now)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if !got.Equal(c.want) {
+			t.Fatalf("%s: got %s, want %s", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestNextExecTimeInvalid(t *testing.T) {
+	now := time.Now()
+	cases := []string{
+		"",
+		"0 0 9 * *",
+		"0 0 9 * * ? *",
+		"0 0 9 31 2 ?",
+		// @reboot只在客户端启动时执行，不能按时间调度
+		"@reboot",
+	}
+	for _, expr := range cases {
+		if _, err := NewParse(expr).NextExecTime(now); err == nil {
+			t.Fatalf("%q should be rejected", expr)
+		}
+	}
+}
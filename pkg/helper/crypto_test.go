@@ -0,0 +1,64 @@
+package helper
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestAesEncrypt(t *testing.T) {
+	cases := []struct {
+		name      string
+		plaintext string
+	}{
+		{"empty", ""},
+		{"ascii", "password"},
+		{"unicode", "数据库密码"},
+		{"multiline", "-----BEGIN KEY-----\nabc\n-----END KEY-----\n"},
+	}
+	for _, c := range cases {
+		sealed, err := AesEncrypt("key", c.plaintext)
+		if err != nil {
+			t.Fatalf("%s: encrypt failed, %v", c.name, err)
+		}
+		again, _ := AesEncrypt("key", c.plaintext)
+		if sealed == again {
+			t.Fatalf("%s: encrypting twice should use different nonces", c.name)
+		}
+		plain, err := AesDecrypt("key", sealed)
+		if err != nil {
+			t.Fatalf("%s: decrypt failed, %v", c.name, err)
+		}
+		if plain != c.plaintext {
+			t.Fatalf("%s: got %q, want %q", c.name, plain, c.plaintext)
+		}
+	}
+}
+
+func TestAesDecryptRejects(t *testing.T) {
+	sealed, err := AesEncrypt("key", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(sealed)
+	data[len(data)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(data)
+	cases := []struct {
+		name       string
+		key        string
+		ciphertext string
+	}{
+		{"empty key", "", sealed},
+		{"wrong key", "other", sealed},
+		{"tampered", "key", tampered},
+		{"not base64", "key", "!!!"},
+		{"too short", "key", base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, c := range cases {
+		if _, err = AesDecrypt(c.key, c.ciphertext); err == nil {
+			t.Fatalf("%s: decrypt should fail", c.name)
+		}
+	}
+	if _, err = AesEncrypt("", "password"); err == nil {
+		t.Fatal("encrypt with empty key should fail")
+	}
+}
@@ -0,0 +1,334 @@
+package mrpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// authMaxSkew 请求时间与本机时间的最大偏差，超出后视为过期请求
+const authMaxSkew = 5 * time.Minute
+
+var (
+	authSecret      []byte
+	errUnauthorized = errors.New("mrpc: unauthorized call")
+)
+
+// SetSecret 设置调用签名使用的共享密钥，为空时不签名也不校验，需要在监听和发起调用之前设置。
+// 请求和响应都带签名，响应的签名绑定请求的随机数，只能防止未持有密钥的一方伪造或重放调用，
+// 参数和返回值仍是明文，需要机密性时配合TLS使用；所有节点和管理端共用同一个密钥，
+// 持有密钥的任一节点都可以冒充其他节点，需要区分节点身份时使用双向证书，由证书识别调用方
+func SetSecret(secret string) {
+	if secret == "" {
+		authSecret = nil
+		return
+	}
+	authSecret = []byte(secret)
+}
+
+// authEnvelope 每次调用的参数单独编码后与签名一起发送，服务端校验通过后才解码参数并执行方法
+type authEnvelope struct {
+	Timestamp int64
+	Nonce     string
+	Sign      string
+	Args      []byte
+}
+
+func (e *authEnvelope) sign(secret []byte, method string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(method + "\n" + strconv.FormatInt(e.Timestamp, 10) + "\n" + e.Nonce + "\n"))
+	h.Write(e.Args)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (e *authEnvelope) decode(body interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(e.Args)).Decode(body)
+}
+
+// nonceCache 记录有效期内已使用的随机数，拒绝重放的请求
+type nonceCache struct {
+	nonces map[string]int64
+	purged int64
+	mux    sync.Mutex
+}
+
+var usedNonces = &nonceCache{nonces: make(map[string]int64)}
+
+func (n *nonceCache) use(nonce string, now int64) bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if now-n.purged > int64(authMaxSkew.Seconds()) {
+		for k, expire := range n.nonces {
+			if expire < now {
+				delete(n.nonces, k)
+			}
+		}
+		n.purged = now
+	}
+	if _, ok := n.nonces[nonce]; ok {
+		return false
+	}
+	n.nonces[nonce] = now + 2*int64(authMaxSkew.Seconds())
+	return true
+}
+
+func randomNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pendingNonces 记录请求使用的随机数，响应按请求序号取出后校验或签名
+type pendingNonces struct {
+	nonces map[uint64]string
+	mux    sync.Mutex
+}
+
+func (p *pendingNonces) put(seq uint64, nonce string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.nonces == nil {
+		p.nonces = make(map[uint64]string)
+	}
+	p.nonces[seq] = nonce
+}
+
+func (p *pendingNonces) take(seq uint64) (string, bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	nonce, ok := p.nonces[seq]
+	delete(p.nonces, seq)
+	return nonce, ok
+}
+
+type authClientCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	secret []byte
+	nonces pendingNonces
+	resp   rpc.Response
+}
+
+func newAuthClientCodec(conn io.ReadWriteCloser, secret []byte) rpc.ClientCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &authClientCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+		secret: secret,
+	}
+}
+
+func (c *authClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	e, err := sealEnvelope(c.secret, r.ServiceMethod, body)
+	if err != nil {
+		return err
+	}
+	c.nonces.put(r.Seq, e.Nonce)
+	if err = c.enc.Encode(r); err != nil {
+		return err
+	}
+	if err = c.enc.Encode(e); err != nil {
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *authClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.resp = *r
+	return nil
+}
+
+func (c *authClientCodec) ReadResponseBody(body interface{}) error {
+	var e authEnvelope
+	if err := c.dec.Decode(&e); err != nil {
+		return err
+	}
+	nonce, ok := c.nonces.take(c.resp.Seq)
+	if body == nil {
+		// 错误响应没有返回值，签名不对时也只是本次调用失败，不影响连接上的其他调用
+		return nil
+	}
+	if !ok {
+		return errors.New("mrpc: unexpected response")
+	}
+	if err := verifyResponse(c.secret, c.resp.ServiceMethod, nonce, c.resp.Error, &e); err != nil {
+		return err
+	}
+	return e.decode(body)
+}
+
+func (c *authClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+type authServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	secret []byte
+	method string
+	seq    uint64
+	nonces pendingNonces
+	closed bool
+}
+
+func newAuthServerCodec(conn io.ReadWriteCloser, secret []byte) rpc.ServerCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &authServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+		secret: secret,
+	}
+}
+
+func (c *authServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.method = r.ServiceMethod
+	c.seq = r.Seq
+	return nil
+}
+
+// ReadRequestBody 签名校验失败时返回错误，rpc服务端直接将错误回复给调用方而不执行方法
+func (c *authServerCodec) ReadRequestBody(body interface{}) error {
+	var e authEnvelope
+	if err := c.dec.Decode(&e); err != nil {
+		return err
+	}
+	c.nonces.put(c.seq, e.Nonce)
+	if body == nil {
+		return nil
+	}
+	if err := c.verify(&e); err != nil {
+		addr := "unknown"
+		if conn, ok := c.rwc.(net.Conn); ok {
+			addr = conn.RemoteAddr().String()
+		}
+		log.Printf("rpc.Serve: %s call %s rejected, %s", addr, c.method, err.Error())
+		return errUnauthorized
+	}
+	return e.decode(body)
+}
+
+func (c *authServerCodec) verify(e *authEnvelope) error {
+	return verifyEnvelope(c.secret, c.method, e)
+}
+
+// sealEnvelope 单独编码调用参数，设置密钥时附带签名
+func sealEnvelope(secret []byte, method string, body interface{}) (*authEnvelope, error) {
+	var args bytes.Buffer
+	if err := gob.NewEncoder(&args).Encode(body); err != nil {
+		return nil, err
+	}
+	e := &authEnvelope{Args: args.Bytes()}
+	if len(secret) == 0 {
+		return e, nil
+	}
+	var err error
+	e.Timestamp = time.Now().Unix()
+	if e.Nonce, err = randomNonce(); err != nil {
+		return nil, err
+	}
+	e.Sign = e.sign(secret, method)
+	return e, nil
+}
+
+// sealResponse 用请求的随机数签名响应，错误响应不编码返回值，调用方据此确认响应对应本次请求且未被篡改
+func sealResponse(secret []byte, method string, nonce string, errMsg string, body interface{}) (*authEnvelope, error) {
+	e := &authEnvelope{Timestamp: time.Now().Unix(), Nonce: nonce}
+	if errMsg == "" {
+		var args bytes.Buffer
+		if err := gob.NewEncoder(&args).Encode(body); err != nil {
+			return nil, err
+		}
+		e.Args = args.Bytes()
+	}
+	if len(secret) > 0 {
+		e.Sign = e.sign(secret, responseMethod(method, errMsg))
+	}
+	return e, nil
+}
+
+func verifyResponse(secret []byte, method string, nonce string, errMsg string, e *authEnvelope) error {
+	if len(secret) == 0 {
+		return nil
+	}
+	if e.Nonce != nonce || !hmac.Equal([]byte(e.Sign), []byte(e.sign(secret, responseMethod(method, errMsg)))) {
+		return errors.New("mrpc: invalid response sign")
+	}
+	return nil
+}
+
+func responseMethod(method string, errMsg string) string {
+	return "response\n" + method + "\n" + errMsg
+}
+
+func verifyEnvelope(secret []byte, method string, e *authEnvelope) error {
+	if !hmac.Equal([]byte(e.Sign), []byte(e.sign(secret, method))) {
+		return errors.New("invalid sign")
+	}
+	now := time.Now().Unix()
+	if skew := now - e.Timestamp; skew > int64(authMaxSkew.Seconds()) || -skew > int64(authMaxSkew.Seconds()) {
+		return errors.New("request expired")
+	}
+	if !usedNonces.use(e.Nonce, now) {
+		return errors.New("replayed request")
+	}
+	return nil
+}
+
+func (c *authServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	nonce, _ := c.nonces.take(r.Seq)
+	e, err := sealResponse(c.secret, r.ServiceMethod, nonce, r.Error, body)
+	if err != nil {
+		return err
+	}
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding response:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	if err = c.enc.Encode(e); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *authServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
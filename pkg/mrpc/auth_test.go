@@ -0,0 +1,137 @@
+package mrpc
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startAuthServer(t *testing.T, secret []byte) string {
+	t.Helper()
+	registerServe(t)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go serve(l, nil, secret)
+	return l.Addr().String()
+}
+
+func dialAuth(t *testing.T, addr string, secret []byte) *client {
+	t.Helper()
+	c := newClient(options{network: "tcp4", addr: addr, secret: secret})
+	if c.err != nil {
+		t.Fatal(c.err)
+	}
+	t.Cleanup(func() {
+		_ = c.client.Close()
+	})
+	return c
+}
+
+func echoWith(c *client) error {
+	var reply string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.call("Serve.Echo", ctx, "hello", &reply); err != nil {
+		return err
+	}
+	if reply != "hello" {
+		return rpc.ServerError("unexpected reply " + reply)
+	}
+	return nil
+}
+
+func TestSignedCall(t *testing.T) {
+	addr := startAuthServer(t, []byte("secret"))
+	c := dialAuth(t, addr, []byte("secret"))
+	for i := 0; i < 3; i++ {
+		if err := echoWith(c); err != nil {
+			t.Fatalf("signed call failed: %v", err)
+		}
+	}
+}
+
+func TestSignedCallRejectsWrongSecret(t *testing.T) {
+	addr := startAuthServer(t, []byte("secret"))
+	c := dialAuth(t, addr, []byte("other"))
+	err := echoWith(c)
+	if err == nil || !strings.Contains(err.Error(), errUnauthorized.Error()) {
+		t.Fatalf("call with wrong secret should be unauthorized, got %v", err)
+	}
+	// 校验失败只拒绝本次调用，连接仍可继续使用
+	if err = echoWith(c); err == nil || !strings.Contains(err.Error(), errUnauthorized.Error()) {
+		t.Fatalf("second call should also be unauthorized, got %v", err)
+	}
+}
+
+func TestSignedCallRejectsUnsignedClient(t *testing.T) {
+	addr := startAuthServer(t, []byte("secret"))
+	c := dialAuth(t, addr, nil)
+	if err := echoWith(c); err == nil {
+		t.Fatal("unsigned call should be refused")
+	}
+}
+
+func TestVerifyRejectsReplayAndExpired(t *testing.T) {
+	secret := []byte("secret")
+	codec := &authServerCodec{secret: secret, method: "Serve.Echo"}
+	e := &authEnvelope{Timestamp: time.Now().Unix(), Args: []byte("args")}
+	e.Nonce, _ = randomNonce()
+	e.Sign = e.sign(secret, "Serve.Echo")
+	if err := codec.verify(e); err != nil {
+		t.Fatalf("valid request rejected: %v", err)
+	}
+	if err := codec.verify(e); err == nil {
+		t.Fatal("replayed request should be rejected")
+	}
+	codec.method = "CrontabServe.Exec"
+	e.Nonce, _ = randomNonce()
+	if err := codec.verify(e); err == nil {
+		t.Fatal("request signed for another method should be rejected")
+	}
+	codec.method = "Serve.Echo"
+	e.Timestamp = time.Now().Add(-2 * authMaxSkew).Unix()
+	e.Sign = e.sign(secret, "Serve.Echo")
+	if err := codec.verify(e); err == nil {
+		t.Fatal("expired request should be rejected")
+	}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	secret := []byte("secret")
+	e, err := sealResponse(secret, "Serve.Echo", "nonce", "", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifyResponse(secret, "Serve.Echo", "nonce", "", e); err != nil {
+		t.Fatalf("valid response rejected: %v", err)
+	}
+	cases := []struct {
+		name   string
+		secret []byte
+		method string
+		nonce  string
+		errMsg string
+	}{
+		{"wrong secret", []byte("other"), "Serve.Echo", "nonce", ""},
+		{"another request", secret, "Serve.Echo", "other", ""},
+		{"another method", secret, "CrontabServe.Exec", "nonce", ""},
+		{"forged error", secret, "Serve.Echo", "nonce", "failed"},
+	}
+	for _, c := range cases {
+		if err = verifyResponse(c.secret, c.method, c.nonce, c.errMsg, e); err == nil {
+			t.Fatalf("%s: response should be rejected", c.name)
+		}
+	}
+	e.Args = append(e.Args, 0)
+	if err = verifyResponse(secret, "Serve.Echo", "nonce", "", e); err == nil {
+		t.Fatal("tampered response should be rejected")
+	}
+}
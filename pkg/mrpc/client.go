@@ -2,6 +2,7 @@ package mrpc
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/rpc"
@@ -30,7 +31,11 @@ func init() {
 	}
 }
 
+// Call 对端通过长连接注册过时经由该连接调用，否则直接连接对端地址
 func Call(addr string, method string, ctx context.Context, args interface{}, reply interface{}) error {
+	if s := rpcSessions.get(addr); s != nil {
+		return s.client.call(method, ctx, args, reply)
+	}
 	c := rpcClients.get(addr)
 	if c == nil {
 		return errRpc
@@ -56,6 +61,8 @@ func (cs *clients) get(addr string) *client {
 	c := newClient(options{
 		network: "tcp4",
 		addr:    addr,
+		tls:     clientTLS,
+		secret:  authSecret,
 	})
 	if c.err != nil {
 		return nil
@@ -77,6 +84,8 @@ func (cs *clients) del(addr string) {
 type options struct {
 	network string
 	addr    string
+	tls     *tls.Config
+	secret  []byte
 }
 
 type client struct {
@@ -94,11 +103,22 @@ func newClient(options options) *client {
 	return c
 }
 
-func (c *client) dial() (err error) {
-	conn, err := net.DialTimeout(c.options.network, c.options.addr, diaTimeout)
+func dialConn(network string, addr string, config *tls.Config) (net.Conn, error) {
+	if config != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: diaTimeout}, network, addr, config)
+	}
+	return net.DialTimeout(network, addr, diaTimeout)
+}
+
+func (c *client) dial() error {
+	conn, err := dialConn(c.options.network, c.options.addr, c.options.tls)
 	if err != nil {
 		return err
 	}
+	if len(c.options.secret) > 0 {
+		c.client = rpc.NewClientWithCodec(newAuthClientCodec(conn, c.options.secret))
+		return nil
+	}
 	c.client = rpc.NewClient(conn)
 	return nil
 }
@@ -0,0 +1,104 @@
+package mrpc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/gob"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"task/pkg/proto"
+)
+
+// peerAware 参数实现该接口时，服务端解码参数后填充调用方信息，方法内可据此识别调用方而不依赖参数中的自述
+type peerAware interface {
+	SetPeer(p proto.RpcPeer)
+}
+
+// connPeer 双向证书校验通过时记录对端证书中的域名和IP
+func connPeer(conn net.Conn) proto.RpcPeer {
+	var p proto.RpcPeer
+	if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+		p.Addr = host
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		state := c.ConnectionState()
+		if len(state.VerifiedChains) > 0 && len(state.PeerCertificates) > 0 {
+			cert := state.PeerCertificates[0]
+			p.Names = append(p.Names, cert.DNSNames...)
+			for _, ip := range cert.IPAddresses {
+				p.Names = append(p.Names, ip.String())
+			}
+		}
+	}
+	return p
+}
+
+type peerServerCodec struct {
+	rpc.ServerCodec
+	peer proto.RpcPeer
+}
+
+func (c *peerServerCodec) ReadRequestBody(body interface{}) error {
+	if err := c.ServerCodec.ReadRequestBody(body); err != nil {
+		return err
+	}
+	if p, ok := body.(peerAware); ok {
+		p.SetPeer(c.peer)
+	}
+	return nil
+}
+
+// gobServerCodec 与net/rpc默认的编解码一致，用于未设置密钥时包装调用方信息
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding response:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			_ = c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
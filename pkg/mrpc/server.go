@@ -1,11 +1,16 @@
 package mrpc
 
 import (
+	"bufio"
+	"crypto/tls"
 	"log"
 	"net"
 	"net/rpc"
+	"time"
 )
 
+const handshakeTimeout = 10 * time.Second
+
 func ListenAndServer(addr string, serves ...interface{}) {
 	var err error
 	for _, v := range serves {
@@ -22,6 +27,61 @@ func ListenAndServer(addr string, serves ...interface{}) {
 			log.Fatalln("Listen " + addr + " close fail")
 		}
 	}()
-	log.Println("Rpc Serve Listen " + addr)
-	rpc.Accept(l)
+	if serverTLS != nil {
+		log.Println("Rpc Serve Listen " + addr + " with TLS")
+	} else {
+		log.Println("Rpc Serve Listen " + addr)
+	}
+	serve(l, serverTLS, authSecret)
+}
+
+// serve 开启TLS时先完成握手，握手失败(如未提供有效的客户端证书)直接断开连接，设置密钥时校验每次调用的签名
+func serve(l net.Listener, config *tls.Config, secret []byte) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Println("rpc.Serve: accept:", err.Error())
+			return
+		}
+		if config == nil {
+			go serveConn(conn, secret)
+			continue
+		}
+		go func() {
+			tlsConn := tls.Server(conn, config)
+			_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+			if err := tlsConn.Handshake(); err != nil {
+				log.Printf("rpc.Serve: %s handshake failed, %s", conn.RemoteAddr(), err.Error())
+				_ = tlsConn.Close()
+				return
+			}
+			_ = tlsConn.SetDeadline(time.Time{})
+			serveConn(tlsConn, secret)
+		}()
+	}
+}
+
+// serveConn 按连接的首个字节区分节点主动建立的长连接和普通调用连接
+func serveConn(conn net.Conn, secret []byte) {
+	r := bufio.NewReader(conn)
+	if b, err := r.Peek(1); err == nil && b[0] == sessionPreface[0] {
+		acceptSession(conn, r, secret)
+		return
+	}
+	c := &bufferedConn{Conn: conn, r: r}
+	codec := newGobServerCodec(c)
+	if len(secret) > 0 {
+		codec = newAuthServerCodec(c, secret)
+	}
+	rpc.ServeCodec(&peerServerCodec{ServerCodec: codec, peer: connPeer(conn)})
+}
+
+// bufferedConn 从已预读的缓冲中继续读取
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
 }
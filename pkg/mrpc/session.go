@@ -0,0 +1,373 @@
+package mrpc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"task/pkg/proto"
+	"time"
+)
+
+// sessionPreface 长连接建立后首先发送的标识，gob编码的数据不会以0开头，监听端据此区分普通调用连接
+const sessionPreface = "\x00mrpc-session\n"
+
+const (
+	helloMethod   = "Session.Hello"
+	frameHello    = 1
+	frameRequest  = 2
+	frameResponse = 3
+	// sessionQueueSize 读取协程与编解码器之间的缓冲
+	sessionQueueSize = 16
+)
+
+var (
+	rpcSessions      *sessions
+	errSessionClosed = errors.New("mrpc session closed")
+	// sessionValidator 管理端校验节点地址，未设置时不校验
+	sessionValidator func(nodeAddr string) error
+)
+
+// SetSessionValidator 设置接受长连接前对节点地址的校验，需要在监听之前设置
+func SetSessionValidator(f func(nodeAddr string) error) {
+	sessionValidator = f
+}
+
+func init() {
+	rpcSessions = &sessions{
+		sessions: make(map[string]*session),
+	}
+}
+
+// Connect 节点主动连接管理端并保持长连接，管理端通过该连接调用节点，节点调用管理端也复用该连接，断开后自动重连
+func Connect(addr string, nodeAddr string) {
+	for {
+		err := connect(addr, nodeAddr, clientTLS, authSecret)
+		log.Printf("Rpc session to %s closed, %s", addr, err.Error())
+		time.Sleep(pingDuration)
+	}
+}
+
+func connect(addr string, nodeAddr string, config *tls.Config, secret []byte) error {
+	conn, err := dialConn("tcp4", addr, config)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write([]byte(sessionPreface)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	s := newSession(conn, conn, secret)
+	s.peer = connPeer(conn)
+	e, err := sealEnvelope(secret, helloMethod, nodeAddr)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err = s.write(&frame{Kind: frameHello, ServiceMethod: helloMethod, Envelope: *e}); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	rpcSessions.add(addr, s)
+	defer rpcSessions.del(addr, s)
+	log.Printf("Rpc session to %s established", addr)
+	s.serve()
+	return errSessionClosed
+}
+
+// acceptSession 校验节点的握手信息后以节点地址注册长连接，同一节点重新连接时替换旧连接。
+// 长连接上的调用以节点地址识别节点，因此必须配置密钥或双向证书，双向证书时节点地址需与证书一致
+func acceptSession(conn net.Conn, r io.Reader, secret []byte) {
+	preface := make([]byte, len(sessionPreface))
+	if _, err := io.ReadFull(r, preface); err != nil || string(preface) != sessionPreface {
+		_ = conn.Close()
+		return
+	}
+	s := newSession(conn, r, secret)
+	s.peer = connPeer(conn)
+	var hello frame
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	err := s.dec.Decode(&hello)
+	_ = conn.SetReadDeadline(time.Time{})
+	var nodeAddr string
+	if err == nil && hello.Kind != frameHello {
+		err = errors.New("invalid hello")
+	}
+	if err == nil && len(secret) == 0 && len(s.peer.Names) == 0 {
+		err = errors.New("session requires a secret or mutual TLS")
+	}
+	if err == nil && len(secret) > 0 {
+		err = verifyEnvelope(secret, helloMethod, &hello.Envelope)
+	}
+	if err == nil {
+		err = hello.Envelope.decode(&nodeAddr)
+	}
+	if err == nil && len(s.peer.Names) > 0 && !certMatches(s.peer, nodeAddr) {
+		err = fmt.Errorf("node %s does not match the client certificate", nodeAddr)
+	}
+	if err == nil && sessionValidator != nil {
+		err = sessionValidator(nodeAddr)
+	}
+	if err != nil {
+		log.Printf("rpc.Serve: %s session rejected, %s", conn.RemoteAddr(), err.Error())
+		_ = conn.Close()
+		return
+	}
+	s.peer.Node = nodeAddr
+	rpcSessions.add(nodeAddr, s)
+	defer rpcSessions.del(nodeAddr, s)
+	log.Printf("Rpc session from %s(%s) established", nodeAddr, conn.RemoteAddr())
+	s.serve()
+	log.Printf("Rpc session from %s(%s) closed", nodeAddr, conn.RemoteAddr())
+}
+
+// certMatches 节点地址的主机需出现在证书的域名或IP中
+func certMatches(p proto.RpcPeer, nodeAddr string) bool {
+	host, _, err := net.SplitHostPort(nodeAddr)
+	if err != nil {
+		return false
+	}
+	for _, name := range p.Names {
+		if name == host {
+			return true
+		}
+	}
+	return false
+}
+
+// frame 长连接上双向传输的请求和响应，参数和返回值单独编码，读取时无需知道具体类型
+type frame struct {
+	Kind          uint8
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+	Envelope      authEnvelope
+}
+
+// session 一条连接上同时作为rpc服务端和客户端，由读取协程按类型分发请求和响应
+type session struct {
+	conn      net.Conn
+	dec       *gob.Decoder
+	enc       *gob.Encoder
+	encBuf    *bufio.Writer
+	encMux    sync.Mutex
+	secret    []byte
+	requests  chan *frame
+	responses chan *frame
+	client    *client
+	peer      proto.RpcPeer
+	done      chan struct{}
+	once      sync.Once
+}
+
+func newSession(conn net.Conn, r io.Reader, secret []byte) *session {
+	encBuf := bufio.NewWriter(conn)
+	s := &session{
+		conn:      conn,
+		dec:       gob.NewDecoder(r),
+		enc:       gob.NewEncoder(encBuf),
+		encBuf:    encBuf,
+		secret:    secret,
+		requests:  make(chan *frame, sessionQueueSize),
+		responses: make(chan *frame, sessionQueueSize),
+		done:      make(chan struct{}),
+	}
+	s.client = &client{client: rpc.NewClientWithCodec(&sessionClientCodec{s: s})}
+	return s
+}
+
+// serve 阻塞直到连接断开
+func (s *session) serve() {
+	go s.read()
+	go s.keepalive()
+	rpc.ServeCodec(&peerServerCodec{ServerCodec: &sessionServerCodec{s: s}, peer: s.peer})
+	s.close()
+}
+
+func (s *session) read() {
+	defer close(s.requests)
+	defer close(s.responses)
+	for {
+		f := &frame{}
+		if err := s.dec.Decode(f); err != nil {
+			s.close()
+			return
+		}
+		ch := s.responses
+		if f.Kind == frameRequest {
+			ch = s.requests
+		} else if f.Kind != frameResponse {
+			continue
+		}
+		select {
+		case ch <- f:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// keepalive 定时探测对端，及时发现已经失效的连接
+func (s *session) keepalive() {
+	ticker := time.NewTicker(pingDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 2*pingDuration)
+			err := s.client.call(pingMethod, ctx, &proto.EmptyArgs{}, &proto.EmptyReply{})
+			cancel()
+			if err != nil {
+				log.Printf("Rpc session %s ping failed, %s", s.conn.RemoteAddr(), err.Error())
+				s.close()
+				return
+			}
+		}
+	}
+}
+
+func (s *session) write(f *frame) error {
+	s.encMux.Lock()
+	defer s.encMux.Unlock()
+	if err := s.enc.Encode(f); err != nil {
+		return err
+	}
+	return s.encBuf.Flush()
+}
+
+// close 关闭连接后读取协程退出，rpc服务端和客户端随之结束
+func (s *session) close() {
+	s.once.Do(func() {
+		close(s.done)
+		_ = s.conn.Close()
+	})
+}
+
+type sessionServerCodec struct {
+	s      *session
+	req    *frame
+	nonces pendingNonces
+}
+
+func (c *sessionServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	f, ok := <-c.s.requests
+	if !ok {
+		return io.EOF
+	}
+	c.req = f
+	r.ServiceMethod = f.ServiceMethod
+	r.Seq = f.Seq
+	return nil
+}
+
+func (c *sessionServerCodec) ReadRequestBody(body interface{}) error {
+	c.nonces.put(c.req.Seq, c.req.Envelope.Nonce)
+	if body == nil {
+		return nil
+	}
+	if len(c.s.secret) > 0 {
+		if err := verifyEnvelope(c.s.secret, c.req.ServiceMethod, &c.req.Envelope); err != nil {
+			log.Printf("rpc.Serve: %s call %s rejected, %s", c.s.conn.RemoteAddr(), c.req.ServiceMethod, err.Error())
+			return errUnauthorized
+		}
+	}
+	return c.req.Envelope.decode(body)
+}
+
+func (c *sessionServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	nonce, _ := c.nonces.take(r.Seq)
+	e, err := sealResponse(c.s.secret, r.ServiceMethod, nonce, r.Error, body)
+	if err != nil {
+		return err
+	}
+	return c.s.write(&frame{Kind: frameResponse, ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: r.Error, Envelope: *e})
+}
+
+func (c *sessionServerCodec) Close() error {
+	c.s.close()
+	return nil
+}
+
+type sessionClientCodec struct {
+	s      *session
+	resp   *frame
+	nonces pendingNonces
+}
+
+func (c *sessionClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	e, err := sealEnvelope(c.s.secret, r.ServiceMethod, body)
+	if err != nil {
+		return err
+	}
+	c.nonces.put(r.Seq, e.Nonce)
+	return c.s.write(&frame{Kind: frameRequest, ServiceMethod: r.ServiceMethod, Seq: r.Seq, Envelope: *e})
+}
+
+func (c *sessionClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	f, ok := <-c.s.responses
+	if !ok {
+		return io.EOF
+	}
+	c.resp = f
+	r.ServiceMethod = f.ServiceMethod
+	r.Seq = f.Seq
+	r.Error = f.Error
+	return nil
+}
+
+func (c *sessionClientCodec) ReadResponseBody(body interface{}) error {
+	nonce, ok := c.nonces.take(c.resp.Seq)
+	if body == nil {
+		return nil
+	}
+	if !ok {
+		return errors.New("mrpc: unexpected response")
+	}
+	if err := verifyResponse(c.s.secret, c.resp.ServiceMethod, nonce, c.resp.Error, &c.resp.Envelope); err != nil {
+		return err
+	}
+	return c.resp.Envelope.decode(body)
+}
+
+func (c *sessionClientCodec) Close() error {
+	c.s.close()
+	return nil
+}
+
+type sessions struct {
+	sessions map[string]*session
+	mux      sync.RWMutex
+}
+
+func (ss *sessions) get(addr string) *session {
+	ss.mux.RLock()
+	defer ss.mux.RUnlock()
+	return ss.sessions[addr]
+}
+
+func (ss *sessions) add(addr string, s *session) {
+	ss.mux.Lock()
+	old := ss.sessions[addr]
+	ss.sessions[addr] = s
+	ss.mux.Unlock()
+	if old != nil && old != s {
+		old.close()
+	}
+}
+
+func (ss *sessions) del(addr string, s *session) {
+	ss.mux.Lock()
+	defer ss.mux.Unlock()
+	if ss.sessions[addr] == s {
+		delete(ss.sessions, addr)
+	}
+}
@@ -0,0 +1,142 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func waitSession(t *testing.T, addr string) *session {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := rpcSessions.get(addr); s != nil {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s not registered", addr)
+	return nil
+}
+
+func callEcho(addr string) error {
+	var reply string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Call(addr, "Serve.Echo", ctx, "hello", &reply); err != nil {
+		return err
+	}
+	if reply != "hello" {
+		return rpc.ServerError("unexpected reply " + reply)
+	}
+	return nil
+}
+
+func TestSessionCallsBothDirections(t *testing.T) {
+	SetSecret("secret")
+	defer SetSecret("")
+	secret := authSecret
+	manage := startAuthServer(t, secret)
+	// 节点地址不可直接访问，只能经由长连接调用
+	node := "192.0.2.1:9700"
+	go func() {
+		_ = connect(manage, node, nil, secret)
+	}()
+	s := waitSession(t, node)
+	t.Cleanup(s.close)
+	waitSession(t, manage)
+	if err := callEcho(node); err != nil {
+		t.Fatalf("manage to node call failed: %v", err)
+	}
+	if err := callEcho(manage); err != nil {
+		t.Fatalf("node to manage call failed: %v", err)
+	}
+	s.close()
+	deadline := time.Now().Add(5 * time.Second)
+	for rpcSessions.get(node) != nil || rpcSessions.get(manage) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("closed session should be unregistered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 没有长连接时回退为直接连接
+	if err := callEcho(manage); err != nil {
+		t.Fatalf("direct call after session closed failed: %v", err)
+	}
+}
+
+func TestSessionRejectsWrongSecret(t *testing.T) {
+	manage := startAuthServer(t, []byte("secret"))
+	node := "192.0.2.2:9700"
+	done := make(chan error, 1)
+	go func() {
+		done <- connect(manage, node, nil, []byte("other"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session with wrong secret should be closed")
+	}
+	if rpcSessions.get(node) != nil {
+		t.Fatal("session with wrong secret should not be registered")
+	}
+}
+
+// expectRejected 握手被拒绝时连接关闭且不注册
+func expectRejected(t *testing.T, manage string, node string, config *tls.Config, secret []byte) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- connect(manage, node, config, secret)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("session of %s should be closed", node)
+	}
+	if rpcSessions.get(node) != nil {
+		t.Fatalf("session of %s should not be registered", node)
+	}
+}
+
+func TestSessionRejectsUnsignedHello(t *testing.T) {
+	expectRejected(t, startAuthServer(t, []byte("secret")), "192.0.2.3:9700", nil, nil)
+	// 管理端未配置密钥和双向证书时不接受长连接
+	expectRejected(t, startAuthServer(t, nil), "192.0.2.4:9700", nil, nil)
+}
+
+func TestSessionRejectsUnknownNode(t *testing.T) {
+	SetSessionValidator(func(nodeAddr string) error {
+		if nodeAddr != "192.0.2.5:9700" {
+			return errors.New("unknown node")
+		}
+		return nil
+	})
+	defer SetSessionValidator(nil)
+	secret := []byte("secret")
+	manage := startAuthServer(t, secret)
+	expectRejected(t, manage, "192.0.2.6:9700", nil, secret)
+	go func() {
+		_ = connect(manage, "192.0.2.5:9700", nil, secret)
+	}()
+	waitSession(t, "192.0.2.5:9700").close()
+}
+
+func TestSessionBindsNodeToCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "manage", 2)
+	clientCert, clientKey := ca.issue(t, "client", 3)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: serverCert, KeyFile: serverKey})
+	_, clientTLS := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: clientCert, KeyFile: clientKey})
+	manage := startServer(t, serverTLS)
+	// 证书只包含127.0.0.1
+	expectRejected(t, manage, "192.0.2.7:9700", clientTLS, nil)
+	go func() {
+		_ = connect(manage, "127.0.0.1:9701", clientTLS, nil)
+	}()
+	waitSession(t, "127.0.0.1:9701").close()
+}
@@ -0,0 +1,83 @@
+package mrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// TLSOptions 未开启时使用明文传输，配置CA后双向校验证书，未提供有效证书的连接会被拒绝
+type TLSOptions struct {
+	Enable     bool
+	CaFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+var (
+	serverTLS *tls.Config
+	clientTLS *tls.Config
+)
+
+// SetTLS 需要在监听和发起调用之前设置
+func SetTLS(o TLSOptions) error {
+	if !o.Enable {
+		serverTLS, clientTLS = nil, nil
+		return nil
+	}
+	s, c, err := o.build()
+	if err != nil {
+		return err
+	}
+	serverTLS, clientTLS = s, c
+	return nil
+}
+
+// build 节点之间互相调用，同一份证书既用作服务端证书也用作客户端证书
+func (o TLSOptions) build() (*tls.Config, *tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, nil, errors.New("TLS需要配置证书和私钥")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   o.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.CaFile != "" {
+		pool, err := loadCA(o.CaFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.ClientCAs = pool
+		s.ClientAuth = tls.RequireAndVerifyClientCert
+		c.RootCAs = pool
+	}
+	return s, c, nil
+}
+
+// MutualTLS 是否开启了双向证书校验，开启后可由对端证书识别调用方
+func MutualTLS() bool {
+	return serverTLS != nil && serverTLS.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+func loadCA(caFile string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("CA证书解析失败")
+	}
+	return pool, nil
+}
@@ -0,0 +1,258 @@
+package mrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"task/pkg/proto"
+)
+
+type Serve struct{}
+
+func (s *Serve) Ping(request *proto.EmptyArgs, response *proto.EmptyReply) error {
+	return nil
+}
+
+func (s *Serve) Echo(request string, response *string) error {
+	*response = request
+	return nil
+}
+
+var registerOnce sync.Once
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+	name string
+}
+
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	writePEM(t, filepath.Join(dir, name+".pem"), "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key, dir: dir, name: name}
+}
+
+func (ca *testCA) file() string {
+	return filepath.Join(ca.dir, ca.name+".pem")
+}
+
+// issue 签发同时可用于服务端和客户端的证书，返回证书和私钥文件
+func (ca *testCA) issue(t *testing.T, name string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(ca.dir, name+".pem")
+	keyFile := filepath.Join(ca.dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path string, typ string, der []byte) {
+	t.Helper()
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func buildTLS(t *testing.T, o TLSOptions) (*tls.Config, *tls.Config) {
+	t.Helper()
+	s, c, err := o.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func registerServe(t *testing.T) {
+	t.Helper()
+	registerOnce.Do(func() {
+		if err := rpc.Register(new(Serve)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// startServer 在随机端口上启动服务，测试结束时关闭
+func startServer(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	registerServe(t)
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	go serve(l, config, nil)
+	return l.Addr().String()
+}
+
+func echo(addr string, config *tls.Config) error {
+	c := newClient(options{network: "tcp4", addr: addr, tls: config})
+	if c.err != nil {
+		return c.err
+	}
+	defer c.client.Close()
+	var reply string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.call("Serve.Echo", ctx, "hello", &reply); err != nil {
+		return err
+	}
+	if reply != "hello" {
+		return rpc.ServerError("unexpected reply " + reply)
+	}
+	return nil
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "manage", 2)
+	clientCert, clientKey := ca.issue(t, "client", 3)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: serverCert, KeyFile: serverKey})
+	_, clientTLS := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: clientCert, KeyFile: clientKey})
+	addr := startServer(t, serverTLS)
+	if err := echo(addr, clientTLS); err != nil {
+		t.Fatalf("call with valid client cert failed: %v", err)
+	}
+}
+
+func TestMutualTLSRejectsClientWithoutCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "manage", 2)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: serverCert, KeyFile: serverKey})
+	addr := startServer(t, serverTLS)
+	pool, err := loadCA(ca.file())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(addr, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}); err == nil {
+		t.Fatal("call without client cert should be refused")
+	}
+}
+
+func TestMutualTLSRejectsUntrustedClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other")
+	serverCert, serverKey := ca.issue(t, "manage", 2)
+	clientCert, clientKey := other.issue(t, "client", 3)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: serverCert, KeyFile: serverKey})
+	_, clientTLS := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: clientCert, KeyFile: clientKey})
+	addr := startServer(t, serverTLS)
+	if err := echo(addr, clientTLS); err == nil {
+		t.Fatal("call with client cert from another CA should be refused")
+	}
+}
+
+func TestTLSRejectsPlainClient(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, "manage", 2)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: serverCert, KeyFile: serverKey})
+	addr := startServer(t, serverTLS)
+	if err := echo(addr, nil); err == nil {
+		t.Fatal("plaintext call should be refused")
+	}
+}
+
+func TestTLSRejectsUntrustedServer(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other")
+	serverCert, serverKey := other.issue(t, "manage", 2)
+	clientCert, clientKey := ca.issue(t, "client", 3)
+	serverTLS, _ := buildTLS(t, TLSOptions{Enable: true, CertFile: serverCert, KeyFile: serverKey})
+	_, clientTLS := buildTLS(t, TLSOptions{Enable: true, CaFile: ca.file(), CertFile: clientCert, KeyFile: clientKey})
+	addr := startServer(t, serverTLS)
+	if err := echo(addr, clientTLS); err == nil {
+		t.Fatal("server cert from another CA should be rejected by the client")
+	}
+}
+
+func TestPlainCall(t *testing.T) {
+	addr := startServer(t, nil)
+	if err := echo(addr, nil); err != nil {
+		t.Fatalf("plaintext call failed: %v", err)
+	}
+}
+
+func TestSetTLS(t *testing.T) {
+	defer func() {
+		_ = SetTLS(TLSOptions{})
+	}()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	cert, key := ca.issue(t, "client", 2)
+	if err := SetTLS(TLSOptions{Enable: true, CaFile: ca.file(), CertFile: cert, KeyFile: key}); err != nil {
+		t.Fatal(err)
+	}
+	if serverTLS == nil || serverTLS.ClientAuth != tls.RequireAndVerifyClientCert || clientTLS == nil || clientTLS.RootCAs == nil {
+		t.Fatal("mutual TLS should require and verify client certs")
+	}
+	if err := SetTLS(TLSOptions{Enable: true, CaFile: ca.file()}); err == nil {
+		t.Fatal("missing cert and key should be rejected")
+	}
+	if err := SetTLS(TLSOptions{Enable: true, CaFile: cert + ".missing", CertFile: cert, KeyFile: key}); err == nil {
+		t.Fatal("missing CA file should be rejected")
+	}
+	if err := SetTLS(TLSOptions{}); err != nil || serverTLS != nil || clientTLS != nil {
+		t.Fatal("disabled TLS should fall back to plaintext")
+	}
+}
@@ -30,6 +30,16 @@ type IdArgs struct {
 	ID uint `json:"id"`
 }
 
+// RpcPeer 由rpc服务端根据连接填充的调用方信息，调用方传入的值会被覆盖
+type RpcPeer struct {
+	// Addr 对端IP
+	Addr string
+	// Node 经由长连接调用时节点注册的地址
+	Node string
+	// Names 双向证书校验通过时对端证书中的域名和IP
+	Names []string
+}
+
 type DingTalkNoticeArgs struct {
 	Address []string
 	Body    string
@@ -30,6 +30,22 @@ type CrontabActionArgs struct {
 	UserID     uint   `json:"user_id"`
 	NodeID     uint   `json:"node_id"`
 	CrontabIDS []uint `json:"crontab_ids"`
+	RunID      string `json:"run_id"`
+}
+
+type CrontabRunListArgs struct {
+	NodeID    uint `json:"node_id"`
+	CrontabID uint `json:"crontab_id"`
+}
+
+type CrontabRun struct {
+	RunID     string `json:"run_id"`
+	CrontabID uint   `json:"crontab_id"`
+	Name      string `json:"name"`
+	Pid       int    `json:"pid"`
+	StartTime uint   `json:"start_time"`
+	Trigger   string `json:"trigger"`
+	UserID    uint   `json:"user_id"`
 }
 
 type CrontabLogListArgs struct {
@@ -45,3 +61,30 @@ type CrontabLogListReply struct {
 	Total int64               `json:"total"`
 	List  []*model.CrontabLog `json:"list"`
 }
+
+type CrontabLogPurgeReply struct {
+	LastPurgeTime uint           `json:"last_purge_time"`
+	LastPurged    int64          `json:"last_purged"`
+	TotalPurged   int64          `json:"total_purged"`
+	Crontabs      map[uint]int64 `json:"crontabs"`
+}
+
+type CrontabArtifactArgs struct {
+	NodeID    uint   `json:"node_id"`
+	CrontabID uint   `json:"crontab_id"`
+	RunID     string `json:"run_id"`
+	Name      string `json:"name"`
+	Offset    int64  `json:"offset"`
+}
+
+type CrontabArtifact struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	ModTime uint   `json:"mod_time"`
+}
+
+type CrontabArtifactChunk struct {
+	Data []byte
+	Size int64
+	EOF  bool
+}
@@ -4,6 +4,14 @@ import (
 	"task/model"
 )
 
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+	LogStreamHook   = "hook"
+	LogStreamHealth = "health"
+	LogStreamReload = "reload"
+)
+
 type DaemonListArgs struct {
 	NodeID  uint   `json:"node_id"`
 	Keyword string `json:"keyword"`
@@ -30,13 +38,16 @@ type DaemonActionArgs struct {
 	UserID    uint   `json:"user_id"`
 	NodeID    uint   `json:"node_id"`
 	DaemonIDS []uint `json:"daemon_ids"`
+	Instances []uint `json:"instances"`
 }
 
 type DaemonLogArgs struct {
 	NodeID   uint   `json:"node_id"`
 	DaemonID uint   `json:"daemon_id"`
+	Instance uint   `json:"instance"`
 	Date     string `json:"date"`
 	Keyword  string `json:"keyword"`
+	Stream   string `json:"stream"`
 	Offset   uint   `json:"offset"`
 	Size     uint   `json:"size"`
 }
@@ -45,3 +56,84 @@ type DaemonLogReply struct {
 	Offset  uint     `json:"offset"`
 	Content []string `json:"content"`
 }
+
+// DaemonLogSearchArgs Reverse为true时从结束日期的文件末尾往前查找
+type DaemonLogSearchArgs struct {
+	NodeID    uint   `json:"node_id"`
+	DaemonID  uint   `json:"daemon_id"`
+	Instance  uint   `json:"instance"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Keyword   string `json:"keyword"`
+	Stream    string `json:"stream"`
+	Context   uint   `json:"context"`
+	Reverse   bool   `json:"reverse"`
+	Limit     uint   `json:"limit"`
+}
+
+// DaemonLogMatch Line为匹配行在当天日志中的行号，从1开始，Offset为匹配行在当天日志中的字节偏移，可用于按偏移读取
+type DaemonLogMatch struct {
+	Date    string   `json:"date"`
+	Line    uint     `json:"line"`
+	Offset  int64    `json:"offset"`
+	Before  []string `json:"before"`
+	Content string   `json:"content"`
+	After   []string `json:"after"`
+}
+
+type DaemonLogSearchReply struct {
+	Matches   []*DaemonLogMatch `json:"matches"`
+	Truncated bool              `json:"truncated"`
+}
+
+type DaemonLogChunk struct {
+	Data []byte
+	Size int64
+	EOF  bool
+}
+
+// DaemonHistoryArgs Instance为空时查询全部实例
+type DaemonHistoryArgs struct {
+	NodeID    uint   `json:"node_id"`
+	DaemonID  uint   `json:"daemon_id"`
+	Instance  *uint  `json:"instance"`
+	Event     string `json:"event"`
+	StartTime uint   `json:"start_time"`
+	EndTime   uint   `json:"end_time"`
+	Pagination
+}
+
+type DaemonHistoryReply struct {
+	Total int64              `json:"total"`
+	List  []*model.DaemonLog `json:"list"`
+}
+
+type DaemonStatArgs struct {
+	NodeID    uint   `json:"node_id"`
+	DaemonIDS []uint `json:"daemon_ids"`
+}
+
+// DaemonStat 进程树的资源占用采样，Rss单位为字节，Cpu为百分比
+type DaemonStat struct {
+	Time    uint    `json:"time"`
+	Procs   int     `json:"procs"`
+	Rss     uint64  `json:"rss"`
+	Cpu     float64 `json:"cpu"`
+	Fds     int     `json:"fds"`
+	Threads int     `json:"threads"`
+}
+
+type DaemonInstanceStat struct {
+	DaemonID uint          `json:"daemon_id"`
+	Instance uint          `json:"instance"`
+	Pid      int           `json:"pid"`
+	Samples  []*DaemonStat `json:"samples"`
+}
+
+type DaemonReloadResult struct {
+	DaemonID uint   `json:"daemon_id"`
+	Name     string `json:"name"`
+	Instance uint   `json:"instance"`
+	Success  bool   `json:"success"`
+	Message  string `json:"message"`
+}
@@ -0,0 +1,49 @@
+package proto
+
+import "regexp"
+
+const SecretMask = "******"
+
+// SecretRefReg 环境变量中引用密钥的写法 ${secret:name}
+var SecretRefReg = regexp.MustCompile(`\$\{secret:([A-Za-z0-9_.\-]+)\}`)
+
+// SecretRefNames 返回环境变量中引用的密钥名称
+func SecretRefNames(env []string) []string {
+	var names []string
+	for _, e := range env {
+		for _, m := range SecretRefReg.FindAllStringSubmatch(e, -1) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+type SecretListArgs struct {
+	Name string `json:"name"`
+	Pagination
+}
+
+type SecretAddArgs struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Desc    string `json:"desc"`
+	RoleIDS []uint `json:"role_ids"`
+}
+
+type SecretEditArgs struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Desc    string `json:"desc"`
+	RoleIDS []uint `json:"role_ids"`
+}
+
+type SecretResolveArgs struct {
+	Address string
+	Names   []string
+	Peer    RpcPeer
+}
+
+func (a *SecretResolveArgs) SetPeer(p RpcPeer) {
+	a.Peer = p
+}